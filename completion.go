@@ -0,0 +1,207 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// completionKind describes what sort of argument a flag accepts, for the
+// purposes of shell completion
+type completionKind int
+
+const (
+	completeNone    completionKind = iota // flag takes no argument (booleans)
+	completeAny                           // flag takes an arbitrary argument
+	completeChoices                       // flag takes one of a set of values
+	completeFile                          // flag takes a filename
+	completeDir                           // flag takes a directory name
+)
+
+// getCompletionKind determines how the argument to a flag should be completed.
+// Fields whose help message specifies a type of 'file', 'filename' or 'path'
+// complete filenames, and fields specifying 'dir' or 'directory' complete
+// directories.
+func getCompletionKind(f field) completionKind {
+	if f.boolField {
+		return completeNone
+	}
+	if len(f.options.oneof) > 0 {
+		return completeChoices
+	}
+	name, _ := parseHelpType(f.options.help)
+	switch strings.ToLower(name) {
+	case "file", "filename", "path":
+		return completeFile
+	case "dir", "directory":
+		return completeDir
+	}
+	return completeAny
+}
+
+// printCompletion writes a completion script for the specified shell to w.
+// Supported shells are bash, zsh and fish. The fields of slices of structs
+// can't be completed, since their names depend on the index, so are omitted.
+func printCompletion(w io.Writer, shell string, fields []field, c context) error {
+	prog := filepath.Base(os.Args[0])
+	var completable []field
+	for _, f := range fields {
+		if !isTemplateField(f) {
+			completable = append(completable, f)
+		}
+	}
+	fields = usageFields(completable, c)
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, prog, fields)
+	case "zsh":
+		writeZshCompletion(w, prog, fields)
+	case "fish":
+		writeFishCompletion(w, prog, fields)
+	default:
+		return fmt.Errorf("unsupported shell for completion: %q", shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, prog string, fields []field) {
+	fn := "_" + shellIdent(prog) + "_completion"
+	flags := make([]string, 0, len(fields)*2)
+
+	fmt.Fprintf(w, "# bash completion for %s\n", prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprint(w, "\tlocal cur prev\n")
+	fmt.Fprint(w, "\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprint(w, "\tprev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+	fmt.Fprint(w, "\tcase \"$prev\" in\n")
+	for _, f := range fields {
+		names := completionFlagNames(f)
+		flags = append(flags, names...)
+
+		var reply string
+		switch getCompletionKind(f) {
+		case completeNone:
+			continue
+		case completeChoices:
+			reply = fmt.Sprintf(`$(compgen -W %s -- "$cur")`, shellQuote(strings.Join(f.options.oneof, " ")))
+		case completeFile:
+			reply = `$(compgen -f -- "$cur")`
+		case completeDir:
+			reply = `$(compgen -d -- "$cur")`
+		}
+		for i := range names {
+			names[i] = shellQuote(names[i])
+		}
+		fmt.Fprintf(w, "\t%s)\n", strings.Join(names, "|"))
+		fmt.Fprintf(w, "\t\tCOMPREPLY=(%s)\n", reply)
+		fmt.Fprint(w, "\t\treturn\n\t\t;;\n")
+	}
+	fmt.Fprint(w, "\tesac\n\n")
+	fmt.Fprint(w, "\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(flags, " ")))
+	fmt.Fprint(w, "\telse\n")
+	fmt.Fprint(w, "\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprint(w, "\tfi\n}\n\n")
+	fmt.Fprintf(w, "complete -F %s %s\n", fn, prog)
+}
+
+func writeZshCompletion(w io.Writer, prog string, fields []field) {
+	fn := "_" + shellIdent(prog)
+
+	fmt.Fprintf(w, "#compdef %s\n\n", prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprint(w, "\t_arguments -s \\\n")
+	for _, f := range fields {
+		typeName, help := getTypeAndHelp(&f)
+		var spec string
+		if help != "" {
+			spec = "[" + zshEscape(help) + "]"
+		}
+		switch getCompletionKind(f) {
+		case completeNone:
+		case completeChoices:
+			spec += ":" + zshEscape(typeName) + ":(" + strings.Join(f.options.oneof, " ") + ")"
+		case completeFile:
+			spec += ":" + zshEscape(typeName) + ":_files"
+		case completeDir:
+			spec += ":" + zshEscape(typeName) + ":_files -/"
+		default:
+			spec += ":" + zshEscape(typeName) + ": "
+		}
+		if f.options.short != 0 {
+			short, long := "-"+string(f.options.short), "--"+f.flagName
+			fmt.Fprintf(w, "\t\t'(%s %s)'{%s,%s}%s \\\n", short, long, short, long, shellQuote(spec))
+		} else {
+			fmt.Fprintf(w, "\t\t%s \\\n", shellQuote("--"+f.flagName+spec))
+		}
+	}
+	fmt.Fprint(w, "\t\t'*:argument:_files'\n}\n\n")
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n", fn)
+	fmt.Fprintf(w, "\t%s \"$@\"\nelse\n\tcompdef %s %s\nfi\n", fn, fn, prog)
+}
+
+func writeFishCompletion(w io.Writer, prog string, fields []field) {
+	fmt.Fprintf(w, "# fish completion for %s\n", prog)
+	for _, f := range fields {
+		_, help := getTypeAndHelp(&f)
+		fmt.Fprintf(w, "complete -c %s", prog)
+		if f.options.short != 0 {
+			fmt.Fprintf(w, " -s %s", fishQuote(string(f.options.short)))
+		}
+		fmt.Fprintf(w, " -l %s", f.flagName)
+		switch getCompletionKind(f) {
+		case completeNone:
+		case completeChoices:
+			fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(f.options.oneof, " ")))
+		case completeFile:
+			fmt.Fprint(w, " -r -F")
+		case completeDir:
+			fmt.Fprint(w, " -x -a '(__fish_complete_directories)'")
+		default:
+			fmt.Fprint(w, " -x")
+		}
+		if help != "" {
+			fmt.Fprintf(w, " -d %s", fishQuote(help))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// completionFlagNames returns all of the ways a flag may be specified on the
+// command line
+func completionFlagNames(f field) []string {
+	names := []string{"--" + f.flagName}
+	if f.options.short != 0 {
+		names = append(names, "-"+string(f.options.short))
+	}
+	return names
+}
+
+// shellIdent converts a program name into something that can be used as part
+// of a shell function name
+func shellIdent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// shellQuote single-quotes a string for bash and zsh
+func shellQuote(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `'\''`) + `'`
+}
+
+// fishQuote single-quotes a string for fish, which allows escaping within
+// single quotes
+func fishQuote(s string) string {
+	return `'` + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + `'`
+}
+
+// zshEscape escapes characters that are special within an _arguments spec
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
@@ -1,19 +1,43 @@
 package conf
 
 import (
+	gocontext "context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 // ErrInvalidStruct indicates that a configuration struct is not the correct type.
 var ErrInvalidStruct = errors.New("configuration must be a struct pointer")
 
 type context struct {
-	confFlag string
-	confFile string
-	sources  []Source
+	confFlag     string
+	confFiles    []string
+	dropIns      map[string]bool   // which of confFiles are drop-in patterns
+	formats      map[string]Format // formats of confFiles other than FormatConf
+	templateFlag string
+	description  string
+	sources      []Source
+	types        typeConversions
+	ctx          gocontext.Context
+	order        []SourceID
+	disabled     map[SourceID]bool
+	runner       *commandRunner
+	interpolate  bool
+	provenance   *Provenance
+}
+
+// customType returns the conversion registered for a type by the options,
+// creating the map of conversions if needed
+func (c *context) customType(t reflect.Type) builtinType {
+	if c.types == nil {
+		c.types = make(typeConversions)
+	}
+	return c.types[t]
 }
 
 // Parse parses configuration into the provided struct
@@ -29,8 +53,13 @@ func ParseWithArgs(confStruct interface{}, options ...Option) ([]string, error)
 	for _, option := range options {
 		option(&c)
 	}
+	if c.provenance != nil {
+		c.provenance.reset()
+	}
 
-	fields, err := extractFields(nil, confStruct)
+	// until the sources are known, slices of structs can't be sized, so use
+	// template fields for flag parsing and usage
+	fields, err := extractor{template: sliceIndexTemplate, types: c.types}.extract(nil, nil, confStruct)
 	if err != nil {
 		return nil, err
 	}
@@ -39,56 +68,72 @@ func ParseWithArgs(confStruct interface{}, options ...Option) ([]string, error)
 		return nil, errors.New("no settable flags found in struct")
 	}
 
-	sources := make([]Source, 0, 3)
+	order, err := c.sourceOrder()
+	if err != nil {
+		return nil, err
+	}
 
 	// Process flags and create flag source. If help is requested, print useage
-	// and exit.
-	fs, args, err := newFlagSource(fields, []string{c.confFlag})
+	// and exit. If flags are disabled, only the special flags are recognized.
+	flagFields := fields
+	if !c.sourceEnabled(SourceFlags) {
+		flagFields = nil
+	}
+	fs, args, err := newFlagSource(flagFields, c)
 	switch err {
 	case nil:
 	case errHelpWanted:
-		printUsage(fields, c)
+		printUsage(os.Stderr, fields, c)
 		os.Exit(1)
+	case errTemplateWanted:
+		template, err := ConfigTemplate(confStruct, FormatConf, options...)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Print(template)
+		os.Exit(0)
 	default:
+		// if a completion script is requested, print it and exit
+		if cw, ok := err.(*errCompletionWanted); ok {
+			if err := printCompletion(os.Stdout, cw.shell, flagFields, c); err != nil {
+				return nil, err
+			}
+			os.Exit(0)
+		}
 		return nil, err
 	}
 
-	sources = append(sources, fs)
-
-	// create config file source, if specified
-	if c.confFile != "" || c.confFlag != "" {
-		configFile := c.confFile
-		fromFlag := false
-		// if there's a config file flag, and it's set, use that filename instead
-		if configFileFromFlags, ok := fs.Get([]string{c.confFlag}); ok {
-			configFile = configFileFromFlags
-			fromFlag = true
-		}
-		cs, err := newConfSource(configFile)
-		if err != nil {
-			if os.IsNotExist(err) {
-				// The file doesn't exist. If it was specified by a flag, treat this
-				// as an error, since presumably the user either made a mistake, or
-				// the file they deliberately specified isn't there
-				if fromFlag {
-					return nil, err
-				}
-			} else {
+	sources := make([]Source, 0, len(order)+len(c.sources))
+	for _, id := range order {
+		switch id {
+		case SourceFlags:
+			sources = append(sources, fs)
+		case SourceFile:
+			// create config file sources, if specified
+			cs, err := configFileSources(fs, c)
+			if err != nil {
 				return nil, err
 			}
-		} else {
-			sources = append(sources, cs)
+			sources = append(sources, cs...)
+		case SourceEnv:
+			sources = append(sources, new(envSource))
+		case SourceExtra:
+			sources = append(sources, c.sources...)
 		}
 	}
 
-	// create env souce
-	es := new(envSource)
-	sources = append(sources, es)
+	// extract the real fields, sizing any slices of structs from the sources
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = gocontext.Background()
+	}
+	fields, err = extractor{size: sourceSizer(ctx, sources, c.types), types: c.types}.extract(nil, nil, confStruct)
+	if err != nil {
+		return nil, err
+	}
 
-	// append any additional sources
-	sources = append(sources, c.sources...)
 	// process all fields
-	if err := processFields(sources, fields); err != nil {
+	if err := processFields(ctx, sources, fields, c); err != nil {
 		// if there's an error, we should zero out all fields to avoid the case
 		// where a user might not be checking the error and could end up with a
 		// partially-populated struct.
@@ -97,17 +142,86 @@ func ParseWithArgs(confStruct interface{}, options ...Option) ([]string, error)
 		}
 		return nil, err
 	}
+	if c.provenance != nil {
+		c.provenance.record(fields)
+	}
 
 	return args, nil
 }
 
-func processFields(sources []Source, fields []field) error {
-	for _, field := range fields {
+// configFileSources creates the sources for the config files, if any were
+// specified, using the filenames from the config file flag if it was given.
+// Since later files override earlier ones, the sources are returned in
+// reverse order, from highest precedence to lowest.
+func configFileSources(fs *flagSource, c context) ([]Source, error) {
+	configFiles := c.confFiles
+	fromFlag := false
+	// if there's a config file flag, and it's set, use those filenames instead
+	if files := fs.configFiles(); len(files) > 0 {
+		configFiles = files
+		fromFlag = true
+	}
+	// expand drop-in directories into their fragments, which like the files
+	// from the flag are always in the conf format
+	var files []string
+	var formats []Format
+	for _, file := range configFiles {
+		if !fromFlag && c.dropIns[file] {
+			fragments, err := filepath.Glob(file)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, fragments...)
+			formats = append(formats, make([]Format, len(fragments))...)
+			continue
+		}
+		files = append(files, file)
+		if fromFlag {
+			formats = append(formats, FormatConf)
+		} else {
+			formats = append(formats, c.formats[file])
+		}
+	}
+
+	sources := make([]Source, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		cs, err := newFileSource(files[i], formats[i])
+		if err != nil {
+			// The file doesn't exist. If it was specified by a flag, treat this
+			// as an error, since presumably the user either made a mistake, or
+			// the file they deliberately specified isn't there
+			if os.IsNotExist(err) && !fromFlag {
+				continue
+			}
+			return nil, err
+		}
+		sources = append(sources, cs)
+	}
+	return sources, nil
+}
+
+func processFields(ctx gocontext.Context, sources []Source, fields []field, c context) error {
+	// find the raw value of every field, so that they can refer to each other
+	values := make([]rawValue, len(fields))
+	for i, field := range fields {
 		var value string
+		var typed interface{}
 		var found bool
 		for _, source := range sources {
-			value, found = source.Get(field.key)
+			var err error
+			value, typed, found, err = lookup(ctx, source, field)
+			if err != nil {
+				return err
+			}
 			if found {
+				fields[i].source = sourceName(source)
+				// structured strings are handled like any other, so that
+				// they may be commands
+				if _, ok := typed.(string); ok {
+					typed = nil
+				}
+				interpolate, exec := c.resolution(source)
+				values[i] = rawValue{value: value, typed: typed, interpolate: interpolate, exec: exec, secret: field.isSecret()}
 				break
 			}
 		}
@@ -116,21 +230,213 @@ func processFields(sources []Source, fields []field) error {
 				return fmt.Errorf("required field %s is missing value", field.name)
 			}
 			value = field.options.defaultStr
+			if value != "" {
+				fields[i].source = sourceDefault
+			}
+			values[i] = rawValue{value: value, interpolate: c.interpolate, exec: c.runner != nil, secret: field.isSecret()}
 		}
-		if value != "" {
-			if err := processField(value, field.field); err != nil {
-				return &processError{
-					fieldName: field.name,
-					typeName:  field.field.Type().String(),
-					value:     value,
-					err:       err,
+	}
+
+	in := newInterpolator(ctx, c.runner, fields, values)
+	for i, field := range fields {
+		value, typed := values[i].value, values[i].typed
+		if values[i].interpolate || values[i].exec {
+			var err error
+			switch {
+			case typed != nil && values[i].interpolate:
+				if typed, err = in.expandTyped(typed); err == nil {
+					value = valueString(typed, field.options)
 				}
+			case typed == nil:
+				_, _, err = in.lookup(field.envName)
+				value = in.expanded[field.envName]
+			}
+			if err != nil {
+				return fmt.Errorf("conf: error resolving field %s: %w", field.name, err)
+			}
+		}
+		if value != "" {
+			choice, err := checkOneOf(value, field)
+			if err != nil {
+				return field.processError(value, err)
+			}
+			// structured values are assigned directly, unless they must be
+			// checked against the field's choices as strings
+			if typed != nil && len(field.options.oneof) == 0 {
+				err = processValue(typed, field.field, field.options)
+			} else {
+				err = processField(choice, field.field, field.options)
+			}
+			if err != nil {
+				return field.processError(value, err)
 			}
 		}
 	}
 	return nil
 }
 
+// resolution reports whether values from a source may contain references to
+// be interpolated or commands to run, which are only enabled for config files.
+// Values from flags and the environment were already expanded by the shell,
+// and those from additional sources are returned verbatim.
+func (c context) resolution(source Source) (interpolate bool, exec bool) {
+	switch source.(type) {
+	case *confSource, *dotenvSource, *jsonSource:
+		return c.interpolate, c.runner != nil
+	}
+	return false, false
+}
+
+// lookup gets the value of a field from a source, using the richest interface
+// the source implements. Errors from sources, or from ctx, abort parsing.
+func lookup(ctx gocontext.Context, source Source, f field) (value string, typed interface{}, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, false, fmt.Errorf("conf: %w", err)
+	}
+	// errors must never be ignored, so sources which can fail are asked for
+	// structured values only if they can report errors with them
+	switch s := source.(type) {
+	case ValueErrorSource:
+		typed, found, err = s.GetValueE(ctx, f.key)
+		value = valueString(typed, f.options)
+	case ErrorSource:
+		value, found, err = s.GetE(ctx, f.key)
+	case ValueSource:
+		typed, found = s.GetValue(f.key)
+		value = valueString(typed, f.options)
+	default:
+		value, found = s.Get(f.key)
+	}
+	if err != nil {
+		return "", nil, false, fmt.Errorf("conf: error reading field %s from %s: %w", f.name, sourceName(source), err)
+	}
+	if !found {
+		typed = nil
+	}
+	return value, typed, found, nil
+}
+
+// sourceSizer returns a function which discovers the length of slices of
+// structs from the sources, by looking for the fields of successive elements
+// until none of them are found
+func sourceSizer(ctx gocontext.Context, sources []Source, types typeConversions) func(key []string, slice reflect.Value) (int, error) {
+	e := extractor{types: types}
+	e.size = func(key []string, slice reflect.Value) (int, error) {
+		// the built-in sources list the elements they have values for, so
+		// that gaps can be detected
+		listed := make(map[int]bool)
+		last := -1
+		for _, source := range sources {
+			if is, ok := source.(indexedSource); ok {
+				for _, i := range is.indexes(key) {
+					listed[i] = true
+					if i > last {
+						last = i
+					}
+				}
+			}
+		}
+		for n := 0; ; n++ {
+			if listed[n] {
+				continue
+			}
+			elem := reflect.New(derefType(slice.Type().Elem()))
+			fields, err := e.extract(appendKey(key, strconv.Itoa(n)), nil, elem.Interface())
+			if err != nil {
+				return 0, err
+			}
+			found, err := anyFieldFound(ctx, sources, fields)
+			if err != nil {
+				return 0, err
+			}
+			if !found {
+				if n < last {
+					return 0, fmt.Errorf("conf: %s has element %d but not element %d", getEnvName(key), last, n)
+				}
+				return n, nil
+			}
+		}
+	}
+	return e.size
+}
+
+// indexedSource is implemented by sources which can list the elements of a
+// slice of structs they have values for
+type indexedSource interface {
+	// indexes returns the index of every element of the slice at key with a
+	// value in the source
+	indexes(key []string) []int
+}
+
+// elementIndexes returns the indexes of the elements of the slice named
+// prefix, for which there are values among names, such as 2 for
+// BACKENDS_2_HOST with the prefix BACKENDS and the separator "_"
+func elementIndexes(names []string, prefix string, sep string) []int {
+	var indexes []int
+	for _, name := range names {
+		rest, ok := strings.CutPrefix(name, prefix+sep)
+		if !ok {
+			continue
+		}
+		index, _, _ := strings.Cut(rest, sep)
+		if i, err := strconv.Atoi(index); err == nil && i >= 0 && index == strconv.Itoa(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// mapKeys returns the keys of a map
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// anyFieldFound reports whether any source has a value for any of the fields
+func anyFieldFound(ctx gocontext.Context, sources []Source, fields []field) (bool, error) {
+	for _, f := range fields {
+		for _, source := range sources {
+			_, _, found, err := lookup(ctx, source, f)
+			if err != nil || found {
+				return found, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// checkOneOf ensures a value satisfies the field's `oneof` constraint,
+// returning it with the spelling of the matching choices. For slices and
+// arrays, each element is checked individually.
+func checkOneOf(value string, f field) (string, error) {
+	if len(f.options.oneof) == 0 {
+		return value, nil
+	}
+	isList := false
+	vals := []string{value}
+	if k := derefType(f.field.Type()).Kind(); k == reflect.Slice || k == reflect.Array {
+		var err error
+		if vals, err = splitList(value, f.options.separator()); err != nil {
+			return "", err
+		}
+		isList = true
+	}
+	for i, v := range vals {
+		choice, ok := f.options.choice(v)
+		if !ok {
+			return "", fmt.Errorf("%q is not one of %s", v, strings.Join(f.options.oneof, ", "))
+		}
+		vals[i] = choice
+	}
+	if isList {
+		return joinList(vals, f.options.separator()), nil
+	}
+	return vals[0], nil
+}
+
 // A processError occurs when an environment variable cannot be converted to
 // the type required by a struct field during assignment.
 type processError struct {
@@ -140,6 +446,10 @@ type processError struct {
 	err       error
 }
 
+// errSecretDetails replaces the details of errors assigning to secret fields,
+// which may include the value
+var errSecretDetails = errors.New("invalid value for secret field")
+
 func (e *processError) Error() string {
 	return fmt.Sprintf("conf: error assigning to field %s: converting '%s' to type %s. details: %s", e.fieldName, e.value, e.typeName, e.err)
 }
@@ -153,3 +463,39 @@ type Source interface {
 	// or not the value was set in the source
 	Get(key []string) (value string, found bool)
 }
+
+// ValueSource is a Source of structured configuration data, such as a JSON
+// file. GetValue returns values as a string, number, bool, []interface{} or
+// map[string]interface{}, which are assigned directly to fields, item by item
+// for slices, arrays and maps, rather than being converted from strings. Get
+// is still used where a string is needed.
+type ValueSource interface {
+	Source
+	// GetValue takes a location specified by a key and returns a value and
+	// whether or not the value was set in the source
+	GetValue(key []string) (value interface{}, found bool)
+}
+
+// ErrorSource is a Source which may fail, such as one backed by a remote
+// service. GetE is used in place of Get while parsing, and is passed the
+// context provided with WithContext. If it returns an error, parsing is
+// aborted with an error wrapping it and naming the source. Sources which are
+// both ErrorSources and ValueSources are only asked for strings with GetE,
+// unless they implement ValueErrorSource.
+type ErrorSource interface {
+	Source
+	// GetE takes a location specified by a key and returns a string, whether
+	// or not the value was set in the source, and any error encountered
+	GetE(ctx gocontext.Context, key []string) (value string, found bool, err error)
+}
+
+// ValueErrorSource is a source of structured configuration data which may
+// fail. GetValueE is used in place of Get, GetE and GetValue while parsing,
+// returning values as for ValueSource and errors as for ErrorSource.
+type ValueErrorSource interface {
+	Source
+	// GetValueE takes a location specified by a key and returns a value,
+	// whether or not the value was set in the source, and any error
+	// encountered
+	GetValueE(ctx gocontext.Context, key []string) (value interface{}, found bool, err error)
+}
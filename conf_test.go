@@ -1,9 +1,25 @@
 package conf
 
 import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TODO: Need a better solution for prepArgs/prepEnv(), since forgetting them
@@ -237,6 +253,1608 @@ func TestNoPartialConfig(t *testing.T) {
 	assert(t, c.NotNeeded == "")
 }
 
+func TestOneOfRejectsInvalidValues(t *testing.T) {
+	type oneOf struct {
+		Mode  string   `conf:"oneof:fast slow,default:fast"`
+		Modes []string `conf:"oneof:a b"`
+	}
+	var c oneOf
+	prepArgs("--modes", "a,b")
+	prepEnv()
+	err := Parse(&c)
+	assert(t, err == nil)
+	assert(t, c.Mode == "fast")
+	assert(t, len(c.Modes) == 2)
+
+	prepArgs("--mode", "medium")
+	err = Parse(&c)
+	assert(t, err != nil)
+	assert(t, strings.Contains(err.Error(), `"medium" is not one of fast, slow`))
+
+	prepArgs("--modes", "a,c")
+	err = Parse(&c)
+	assert(t, err != nil)
+	assert(t, strings.Contains(err.Error(), `"c" is not one of a, b`))
+}
+
+func TestOneOfDefaultMustBeValid(t *testing.T) {
+	type badDefault struct {
+		Mode string `conf:"oneof:fast slow,default:medium"`
+	}
+	var c badDefault
+	prepArgs()
+	prepEnv()
+	err := Parse(&c)
+	assert(t, err.Error() == `conf: error parsing tags for field Mode: default value "medium" is not one of fast, slow`)
+}
+
+func TestCompletionFlag(t *testing.T) {
+	prepArgs("--completion", "zsh")
+	_, _, err := newFlagSource(nil, context{})
+	cw, ok := err.(*errCompletionWanted)
+	assert(t, ok)
+	assert(t, cw.shell == "zsh")
+}
+
+func TestCompletionScripts(t *testing.T) {
+	type completion struct {
+		Mode   string `conf:"oneof:fast slow,help:the mode"`
+		Output string `conf:"short:o,help:the output 'file'"`
+		Debug  bool
+	}
+	var c completion
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	ctx := context{confFlag: "conf"}
+
+	var bash strings.Builder
+	assert(t, printCompletion(&bash, "bash", fields, ctx) == nil)
+	assert(t, strings.Contains(bash.String(), "COMPREPLY=($(compgen -W 'fast slow' -- \"$cur\"))"))
+	assert(t, strings.Contains(bash.String(), "'--output'|'-o')\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))"))
+	assert(t, strings.Contains(bash.String(), "'--conf')\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))"))
+	assert(t, strings.Contains(bash.String(), "'--debug --mode --output -o --conf --help -h'"))
+
+	var zsh strings.Builder
+	assert(t, printCompletion(&zsh, "zsh", fields, ctx) == nil)
+	assert(t, strings.Contains(zsh.String(), "'--mode[the mode]:<fast|slow>:(fast slow)'"))
+	assert(t, strings.Contains(zsh.String(), "'(-o --output)'{-o,--output}'[the output file]:<file>:_files'"))
+
+	var fish strings.Builder
+	assert(t, printCompletion(&fish, "fish", fields, ctx) == nil)
+	assert(t, strings.Contains(fish.String(), "-l mode -x -a 'fast slow' -d 'the mode'"))
+	assert(t, strings.Contains(fish.String(), "-s 'o' -l output -r -F -d 'the output file'"))
+
+	err = printCompletion(io.Discard, "tcsh", fields, ctx)
+	assert(t, err.Error() == `unsupported shell for completion: "tcsh"`)
+}
+
+func TestManPage(t *testing.T) {
+	type manConf struct {
+		TimeToWait int  `conf:"short:c,help:how long to wait,required"`
+		Debug      bool `conf:"help:enable debug mode"`
+	}
+	prepArgs()
+	var c manConf
+	page, err := ManPage(&c,
+		WithConfigFile("/etc/test.conf"),
+		WithConfigFileFlag("conf"),
+		WithDescription("a test program"))
+	assert(t, err == nil)
+	for _, want := range []string{
+		".TH TESTING 1\n",
+		".SH NAME\ntesting \\- a test program\n",
+		".SH SYNOPSIS\n.B testing\n",
+		".TP\n\\fB\\-\\-time\\-to\\-wait\\fR, \\fB\\-c\\fR \\fI<int>\\fR\nhow long to wait\n.br\n(required)\n",
+		".TP\n\\fB\\-\\-debug\\fR\nenable debug mode\n",
+		".SH ENVIRONMENT\n.TP\n.B DEBUG\n",
+		".SH FILES\n.TP\n.I /etc/test.conf\nThe system\\-wide configuration file (overridden by \\-\\-conf)\n",
+	} {
+		assert(t, strings.Contains(page, want))
+	}
+
+	// without flags, fields are named as in the usage message
+	page, err = ManPage(&c, WithoutSources(SourceFlags))
+	assert(t, err == nil)
+	assert(t, strings.Contains(page, ".SH OPTIONS\n.TP\n\\fB$DEBUG\\fR\nenable debug mode\n"))
+	assert(t, !strings.Contains(page, "\\-\\-debug"))
+	assert(t, !strings.Contains(page, ".SH ENVIRONMENT"))
+
+	page, err = ManPage(&c, WithoutSources(SourceFlags, SourceEnv))
+	assert(t, err == nil)
+	assert(t, strings.Contains(page, ".TP\n\\fBTIME_TO_WAIT\\fR \\fI<int>\\fR\n"))
+}
+
+func TestReferenceGroupsNestedFields(t *testing.T) {
+	type sub struct {
+		Value int `conf:"help:a sub|value,default:1"`
+	}
+	type refConf struct {
+		Sub      sub
+		Password string `conf:"short:p,help:the password,required,noprint"`
+	}
+	var c refConf
+
+	md, err := MarkdownReference(&c)
+	assert(t, err == nil)
+	assert(t, strings.HasPrefix(md, "## sub\n\n| Flag | Short | Environment | Config Key | Type | Default | Status | Description |\n"))
+	assert(t, strings.Contains(md, "| `--sub-value` |  | `SUB_VALUE` | `SUB_VALUE` | `<int>` | `1` |  | a sub\\|value |\n"))
+	assert(t, strings.Contains(md, "\n## General\n"))
+	assert(t, strings.Contains(md, "| `--password` | `-p` | `PASSWORD` | `PASSWORD` | `<string>` |  | `required, noprint` | the password |\n"))
+
+	h, err := HTMLReference(&c)
+	assert(t, err == nil)
+	assert(t, strings.Contains(h, "<h2>sub</h2>\n<table>\n"))
+	assert(t, strings.Contains(h, "<tr><td><code>--sub-value</code></td><td></td><td><code>SUB_VALUE</code></td><td><code>SUB_VALUE</code></td><td><code>&lt;int&gt;</code></td>"))
+}
+
+func TestJSONSchema(t *testing.T) {
+	type DBConfig struct {
+		Hosts []string `conf:"help:database 'host's"`
+		Port  uint16   `conf:"default:5432"`
+	}
+	type schemaConf struct {
+		DBConfig
+		Sub struct {
+			Modes []string      `conf:"oneof:a b,default:a"`
+			Wait  time.Duration `conf:"default:5s"`
+			Name  string        `conf:"required"`
+		}
+		Ratio float64 `conf:"required"`
+		Debug bool
+		Tags  map[string]int
+	}
+	var c schemaConf
+	b, err := JSONSchema(&c)
+	assert(t, err == nil)
+
+	var got map[string]interface{}
+	assert(t, json.Unmarshal(b, &got) == nil)
+	var want map[string]interface{}
+	assert(t, json.Unmarshal([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["ratio"],
+		"properties": {
+			"hosts": {"type": "array", "items": {"type": "string"}, "description": "database hosts"},
+			"port": {"type": "integer", "minimum": 0, "default": 5432},
+			"sub": {
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["name"],
+				"properties": {
+					"modes": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}, "default": ["a"]},
+					"wait": {"type": "string", "default": "5s"},
+					"name": {"type": "string"}
+				}
+			},
+			"ratio": {"type": "number"},
+			"debug": {"type": "boolean"},
+			"tags": {"type": "object", "additionalProperties": {"type": "integer"}}
+		}
+	}`), &want) == nil)
+	assert(t, reflect.DeepEqual(got, want))
+}
+
+func TestConfigTemplate(t *testing.T) {
+	type templateConf struct {
+		TimeToWait time.Duration `conf:"help:how long to wait,required"`
+		DNSServer  string        `conf:"help:the 'address' of the dns server,default:127.0.0.1"`
+		Password   string        `conf:"noprint,default:hunter2"`
+		Debug      bool
+	}
+	var c templateConf
+	tmpl, err := ConfigTemplate(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, tmpl == `# how long to wait
+# <duration> (required)
+#TIME_TO_WAIT
+
+# the address of the dns server
+# <address>
+#DNS_SERVER 127.0.0.1
+
+# <string>
+#PASSWORD
+
+# <bool>
+#DEBUG false
+`)
+
+	tmpl, err = ConfigTemplate(&c, FormatDotenv)
+	assert(t, err == nil)
+	assert(t, strings.Contains(tmpl, "# <address>\n#DNS_SERVER=127.0.0.1\n"))
+
+	tmpl, err = ConfigTemplate(&c, FormatJSON)
+	assert(t, err == nil)
+	assert(t, strings.Contains(tmpl, `"dns-server": "127.0.0.1"`))
+	assert(t, strings.Contains(tmpl, `"password": null`))
+}
+
+func TestConfigTemplateFlag(t *testing.T) {
+	prepArgs("--print-config-template")
+	_, _, err := newFlagSource(nil, context{templateFlag: "print-config-template"})
+	assert(t, err == errTemplateWanted)
+}
+
+type marshalConf struct {
+	Sub struct {
+		Value int
+	}
+	Name     string
+	Wait     time.Duration
+	Ratio    float64
+	Debug    bool
+	Hosts    []string
+	Weights  map[string]int
+	Optional *string
+	Password string `conf:"noprint"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		format  Format
+		pattern string
+		name    string
+	}{
+		{FormatConf, "conf-test*.conf", "a name with \"quotes\""},
+		{FormatDotenv, "conf-test*.env", " a name # with \"quotes\""},
+		{FormatJSON, "conf-test*.json", " a name # with \"quotes\""},
+	} {
+		in := marshalConf{
+			Name:     tc.name,
+			Wait:     5 * time.Second,
+			Ratio:    0.5,
+			Debug:    true,
+			Hosts:    []string{"a", "b"},
+			Weights:  map[string]int{"a": 1, "b": 2},
+			Password: "secret",
+		}
+		in.Sub.Value = 3
+		b, err := Marshal(&in, tc.format)
+		assert(t, err == nil)
+		assert(t, !strings.Contains(string(b), "secret"))
+
+		testFile, err := ioutil.TempFile("", tc.pattern)
+		if err != nil {
+			panic("error creating temp file for test: " + err.Error())
+		}
+		defer os.Remove(testFile.Name())
+		testFile.Write(b)
+		testFile.Close()
+
+		prepArgs()
+		prepEnv()
+		var out marshalConf
+		err = Parse(&out, WithConfigFileFormat(testFile.Name(), tc.format))
+		assert(t, err == nil)
+		in.Password = ""
+		assert(t, reflect.DeepEqual(in, out))
+	}
+}
+
+func TestMarshalConfUnrepresentable(t *testing.T) {
+	for _, name := range []string{"a # comment", " padded", "line\nbreak"} {
+		_, err := Marshal(&marshalConf{Name: name}, FormatConf)
+		assert(t, err != nil)
+		assert(t, err.Error() == "conf: value of field Name cannot be represented in the conf format")
+	}
+
+	var c struct {
+		Name string `conf:"default:name"`
+	}
+	_, err := Marshal(&c, FormatConf)
+	assert(t, err != nil)
+
+	_, err = Marshal(&struct{}{}, Format(99))
+	assert(t, err != nil)
+	assert(t, err.Error() == "unsupported marshal format: 99")
+}
+
+func TestMarshalRedacted(t *testing.T) {
+	c := marshalConf{Name: "n", Password: "secret"}
+	b, err := MarshalRedacted(&c, FormatDotenv)
+	assert(t, err == nil)
+	assert(t, strings.Contains(string(b), "NAME=n\n"))
+	assert(t, strings.Contains(string(b), "PASSWORD=REDACTED\n"))
+	assert(t, !strings.Contains(string(b), "OPTIONAL"))
+
+	b, err = MarshalRedacted(&c, FormatJSON)
+	assert(t, err == nil)
+	assert(t, strings.Contains(string(b), `"password": "REDACTED"`))
+	assert(t, strings.Contains(string(b), `"sub": {
+    "value": 0
+  }`))
+}
+
+func TestSecretMasks(t *testing.T) {
+	for _, tc := range []struct {
+		tag   string
+		value string
+		want  string
+	}{
+		{"secret", "hunter2", "****"},
+		{"secret:stars", "hunter2", "****"},
+		{"secret:first2", "hunter2", "hu****"},
+		{"secret:last3", "hunter2", "****er2"},
+		{"secret:last3", "abc", "****"},
+		{"secret:sha256", "hunter2", "sha256:f52fbd32b2b3b86f"},
+		{"secret", "", ""},
+	} {
+		opts, err := parseTag(tc.tag)
+		assert(t, err == nil)
+		assert(t, opts.secret.apply(tc.value) == tc.want)
+	}
+
+	_, err := parseTag("secret:first")
+	assert(t, err.Error() == `invalid secret mask "first": expected a positive number of characters`)
+	_, err = parseTag("secret:rot13")
+	assert(t, err.Error() == `unknown secret mask "rot13"`)
+}
+
+func TestSecretsAreMasked(t *testing.T) {
+	type secretConf struct {
+		Name     string
+		Password string `conf:"secret:last2,default:hunter2"`
+		Token    string `conf:"secret:hidden"`
+		Port     int    `conf:"secret"`
+	}
+	prepArgs("--token", "tok", "--port", "notanumber")
+	prepEnv()
+	var c secretConf
+	err := Parse(&c)
+	assert(t, err.Error() == "conf: error assigning to field Port: converting '****' to type int. details: invalid value for secret field")
+	assert(t, !strings.Contains(err.Error(), "notanumber"))
+
+	type secretChoiceConf struct {
+		Mode string `conf:"secret,oneof:a b"`
+	}
+	prepArgs("--mode", "sekrit")
+	err = Parse(&secretChoiceConf{})
+	assert(t, err != nil)
+	assert(t, !strings.Contains(err.Error(), "sekrit"))
+
+	type noprintConf struct {
+		Pin int `conf:"noprint"`
+	}
+	prepArgs("--pin", "hunter2")
+	err = Parse(&noprintConf{})
+	assert(t, err.Error() == "conf: error assigning to field Pin: converting '****' to type int. details: invalid value for secret field")
+	assert(t, !strings.Contains(err.Error(), "hunter2"))
+
+	prepArgs("--token", "tok", "--name", "n")
+	err = Parse(&c)
+	assert(t, err == nil)
+
+	s, err := String(&c)
+	assert(t, err == nil)
+	assert(t, s == "NAME=n PASSWORD=****r2 PORT=****")
+
+	b, err := Marshal(&c, FormatDotenv)
+	assert(t, err == nil)
+	assert(t, string(b) == "NAME=n\nPASSWORD=****r2\nPORT=****\n")
+
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	assert(t, getOptString(fields[1]) == "(secret,default: ****r2)")
+	assert(t, getOptString(fields[2]) == "(secret)")
+}
+
+func TestLogValue(t *testing.T) {
+	type logConf struct {
+		Name string
+		Sub  struct {
+			Wait  time.Duration
+			Hosts []string
+		}
+		Port     *int
+		Token    string `conf:"secret:first1"`
+		Password string `conf:"noprint"`
+	}
+	c := logConf{Name: "n", Token: "tok", Password: "p"}
+	c.Sub.Wait = time.Second
+	c.Sub.Hosts = []string{"a", "b"}
+
+	var buf strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("config", "cfg", LogValue(&c))
+	assert(t, buf.String() == `{"level":"INFO","msg":"config","cfg":{"name":"n","sub":{"wait":1000000000,"hosts":["a","b"]},"port":null,"token":"t****"}}`+"\n")
+
+	attr := LogAttr("cfg", &c)
+	assert(t, attr.Key == "cfg")
+	assert(t, attr.Value.Kind() == slog.KindGroup)
+	assert(t, len(attr.Value.Group()) == 4)
+}
+
+func TestHandler(t *testing.T) {
+	type handlerConf struct {
+		Name     string
+		Port     int `conf:"default:8080"`
+		Debug    bool
+		Token    string `conf:"secret"`
+		Password string `conf:"noprint"`
+	}
+	prepArgs("--name", "n")
+	prepEnv("TOKEN", "tok", "PASSWORD", "p")
+	var c handlerConf
+	var p Provenance
+	assert(t, Parse(&c, WithProvenance(&p)) == nil)
+
+	srv := httptest.NewServer(Handler(&c, WithProvenance(&p)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert(t, err == nil)
+	defer resp.Body.Close()
+	assert(t, resp.Header.Get("Content-Type") == "application/json; charset=utf-8")
+	var got struct {
+		Fields []map[string]interface{}
+	}
+	assert(t, json.NewDecoder(resp.Body).Decode(&got) == nil)
+	assert(t, reflect.DeepEqual(got.Fields, []map[string]interface{}{
+		{"key": "NAME", "flag": "name", "value": "n", "source": "flags"},
+		{"key": "PORT", "flag": "port", "value": 8080.0, "default": "8080", "source": "default"},
+		{"key": "DEBUG", "flag": "debug", "value": false},
+		{"key": "TOKEN", "flag": "token", "value": "****", "source": "environment"},
+		{"key": "PASSWORD", "flag": "password", "value": "REDACTED", "source": "environment"},
+	}))
+
+	textResp, err := http.Get(srv.URL + "?format=text")
+	assert(t, err == nil)
+	defer textResp.Body.Close()
+	text, err := io.ReadAll(textResp.Body)
+	assert(t, err == nil)
+	assert(t, string(text) == `KEY       VALUE       SOURCE       DEFAULT
+NAME      "n"         flags        
+PORT      8080        default      8080
+DEBUG     false                    
+TOKEN     "****"      environment  
+PASSWORD  "REDACTED"  environment  
+`)
+
+	// a failed parse clears the provenance
+	prepArgs("--port", "x")
+	assert(t, Parse(&c, WithProvenance(&p)) != nil)
+	assert(t, p.Source("NAME") == "")
+}
+
+func TestDiff(t *testing.T) {
+	type diffConf struct {
+		Sub struct {
+			Hosts []string
+		}
+		Port     int
+		Name     *string
+		Token    string `conf:"secret:last1"`
+		Password string `conf:"noprint"`
+	}
+	name := "n"
+	a := diffConf{Port: 1, Token: "ab", Password: "p"}
+	b := diffConf{Port: 2, Name: &name, Token: "ac", Password: "p"}
+	b.Sub.Hosts = []string{"a", "b"}
+
+	changes, err := Diff(&a, &b)
+	assert(t, err == nil)
+	assert(t, changes.String() == `SUB_HOSTS:  -> a,b
+PORT: 1 -> 2
+NAME:  -> n
+TOKEN: ****b -> ****c
+`)
+
+	js, err := json.Marshal(changes[1])
+	assert(t, err == nil)
+	assert(t, string(js) == `{"key":"PORT","old":"1","new":"2"}`)
+
+	a = b
+	changes, err = Diff(&a, &b)
+	assert(t, err == nil)
+	assert(t, len(changes) == 0)
+
+	_, err = Diff(&a, &simpleConf{})
+	assert(t, err.Error() == "conf: cannot diff structs of different types")
+}
+
+func TestDiffStructSlices(t *testing.T) {
+	a := backendsConf{Backends: []backend{{Host: "a", Port: 80}, {Host: "b", Port: 80}, {Host: "c", Port: 81}}}
+	b := backendsConf{Backends: []backend{{Host: "a", Port: 80}, {Host: "x", Port: 80}}}
+
+	changes, err := Diff(&a, &b)
+	assert(t, err == nil)
+	assert(t, changes.String() == `BACKENDS_1_HOST: b -> x
+BACKENDS_2_HOST: removed c
+BACKENDS_2_PORT: removed 81
+`)
+	js, err := json.Marshal(changes[1])
+	assert(t, err == nil)
+	assert(t, string(js) == `{"key":"BACKENDS_2_HOST","old":"c","new":"","removed":true}`)
+
+	changes, err = Diff(&b, &a)
+	assert(t, err == nil)
+	assert(t, changes.String() == `BACKENDS_1_HOST: x -> b
+BACKENDS_2_HOST: added c
+BACKENDS_2_PORT: added 81
+`)
+}
+
+func TestNetworkTypes(t *testing.T) {
+	type netConf struct {
+		Endpoint url.URL
+		Proxy    *url.URL
+		Unset    *url.URL
+		Allowed  net.IPNet
+		Mac      net.HardwareAddr
+		Listen   netip.AddrPort
+		Prefix   netip.Prefix
+		Addr     netip.Addr
+		Peers    []net.IP
+		Pattern  *regexp.Regexp
+		Excludes []regexp.Regexp
+	}
+	prepArgs(
+		"--endpoint", "https://example.com:8443/path",
+		"--proxy", "http://proxy:3128",
+		"--allowed", "10.0.0.0/8",
+		"--mac", "00:00:5e:00:53:01",
+		"--listen", "127.0.0.1:8080",
+		"--prefix", "192.168.0.0/16",
+		"--addr", "::1",
+		"--peers", "10.0.0.1,10.0.0.2",
+		"--pattern", "^a+$",
+	)
+	prepEnv()
+	var c netConf
+	err := Parse(&c)
+	assert(t, err == nil)
+	assert(t, c.Endpoint.Host == "example.com:8443")
+	assert(t, c.Proxy.Host == "proxy:3128")
+	assert(t, c.Unset == nil)
+	assert(t, c.Allowed.String() == "10.0.0.0/8")
+	assert(t, c.Mac.String() == "00:00:5e:00:53:01")
+	assert(t, c.Listen.Port() == 8080)
+	assert(t, c.Prefix.Bits() == 16)
+	assert(t, c.Addr == netip.IPv6Loopback())
+	assert(t, len(c.Peers) == 2 && c.Peers[1].Equal(net.IPv4(10, 0, 0, 2)))
+	assert(t, c.Pattern.MatchString("aaa"))
+
+	b, err := Marshal(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, string(b) == `ENDPOINT https://example.com:8443/path
+PROXY http://proxy:3128
+ALLOWED 10.0.0.0/8
+MAC 00:00:5e:00:53:01
+LISTEN 127.0.0.1:8080
+PREFIX 192.168.0.0/16
+ADDR ::1
+PEERS 10.0.0.1,10.0.0.2
+PATTERN ^a+$
+`)
+
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	var names []string
+	for _, f := range fields {
+		name, _ := getTypeAndHelp(&f)
+		names = append(names, name)
+	}
+	assert(t, reflect.DeepEqual(names, []string{
+		"<url>", "<url>", "<url>", "<cidr>", "<mac>", "<ip:port>", "<cidr>", "<ip>", "<ip>,[ip...]", "<regexp>", "<regexp>,[regexp...]",
+	}))
+
+	prepArgs("--allowed", "10.0.0.0")
+	err = Parse(&c)
+	assert(t, err.Error() == "conf: error assigning to field Allowed: converting '10.0.0.0' to type net.IPNet. details: invalid CIDR address: 10.0.0.0")
+}
+
+func TestTimeLayouts(t *testing.T) {
+	type timeConf struct {
+		Start    time.Time
+		Day      time.Time  `conf:"layout:DateOnly"`
+		Epoch    time.Time  `conf:"layout:unix"`
+		EpochMs  *time.Time `conf:"layout:unixms"`
+		Custom   time.Time  `conf:"layout:02/01/2006"`
+		Zone     *time.Location
+		Holidays []time.Time `conf:"layout:DateOnly"`
+	}
+	prepArgs(
+		"--start", "2024-03-01T12:00:00Z",
+		"--day", "2024-03-01",
+		"--epoch", "1700000000",
+		"--epoch-ms", "1700000000123",
+		"--custom", "25/12/2024",
+		"--zone", "America/New_York",
+		"--holidays", "2024-12-25,2025-01-01",
+	)
+	prepEnv()
+	var c timeConf
+	err := Parse(&c)
+	assert(t, err == nil)
+	assert(t, c.Start.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	assert(t, c.Day.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+	assert(t, c.Epoch.Unix() == 1700000000)
+	assert(t, c.EpochMs.UnixMilli() == 1700000000123)
+	assert(t, c.Custom.Month() == time.December)
+	assert(t, c.Zone.String() == "America/New_York")
+	assert(t, len(c.Holidays) == 2 && c.Holidays[1].Year() == 2025)
+
+	b, err := Marshal(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, string(b) == `START 2024-03-01T12:00:00Z
+DAY 2024-03-01
+EPOCH 1700000000
+EPOCH_MS 1700000000123
+CUSTOM 25/12/2024
+ZONE America/New_York
+HOLIDAYS 2024-12-25,2025-01-01
+`)
+
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	var names []string
+	for _, f := range fields {
+		name, _ := getTypeAndHelp(&f)
+		names = append(names, name)
+	}
+	assert(t, reflect.DeepEqual(names, []string{
+		"<2006-01-02T15:04:05Z07:00>", "<2006-01-02>", "<unix>", "<unixms>", "<02/01/2006>", "<timezone>", "<2006-01-02>,[2006-01-02...]",
+	}))
+
+	prepArgs("--day", "March 1")
+	err = Parse(&c)
+	assert(t, err != nil)
+
+	// locations are assigned as pointers, never copied over existing ones
+	type zoneConf struct {
+		Zone *time.Location
+	}
+	prepArgs("--zone", "Local")
+	z := zoneConf{Zone: time.UTC}
+	assert(t, Parse(&z) == nil)
+	assert(t, z.Zone == time.Local)
+	assert(t, time.UTC.String() == "UTC")
+	b, err = Marshal(&z, FormatConf)
+	assert(t, err == nil)
+	assert(t, string(b) == "ZONE Local\n")
+
+	var badZone struct {
+		Zone time.Location
+	}
+	err = Parse(&badZone)
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error parsing field Zone: only *time.Location is supported")
+
+	var badLayout struct {
+		Name string `conf:"layout:DateOnly"`
+	}
+	err = Parse(&badLayout)
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error parsing tags for field Name: `layout` is only supported for time.Time fields")
+}
+
+func TestByteSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want ByteSize
+		str  string
+	}{
+		{"512KiB", 512 << 10, "512KiB"},
+		{"1.5GB", 1500000000, "1500MB"},
+		{"10M", 10 << 20, "10MiB"},
+		{"10mb", 10000000, "10MB"},
+		{"100", 100, "100B"},
+		{"1.5KiB", 1536, "1536B"},
+		{"0", 0, "0B"},
+	} {
+		var b ByteSize
+		assert(t, b.Set(tc.in) == nil)
+		assert(t, b == tc.want)
+		assert(t, b.String() == tc.str)
+	}
+	var b ByteSize
+	assert(t, b.Set("10 parsecs").Error() == `invalid byte size unit "parsecs" in "10 parsecs"`)
+	assert(t, b.Set("KB").Error() == `invalid byte size: "KB"`)
+	assert(t, b.Set("20EiB").Error() == `byte size out of range: "20EiB"`)
+	for _, in := range []string{"NaN", "Inf", "-Inf", "-1", "-1.5KB", "NaNKB"} {
+		assert(t, b.Set(in) != nil)
+	}
+}
+
+func TestRate(t *testing.T) {
+	for _, tc := range []struct {
+		in        string
+		perSecond float64
+		str       string
+	}{
+		{"100/s", 100, "100/s"},
+		{"5MB/s", 5e6, "5MB/s"},
+		{"60/m", 1, "60/m"},
+		{"1.5/ms", 1500, "1.5/ms"},
+		{"10/5m", 10.0 / 300, "10/5m0s"},
+	} {
+		var r Rate
+		assert(t, r.Set(tc.in) == nil)
+		assert(t, r.PerSecond() == tc.perSecond)
+		assert(t, r.String() == tc.str)
+	}
+	var r Rate
+	assert(t, r.Set("100").Error() == `invalid rate "100": expected amount/period`)
+	assert(t, r.Set("100/0s").Error() == "rate period must be positive")
+	for _, in := range []string{"NaN/s", "Inf/s", "-Inf/s", "-1/s", "-5MB/s"} {
+		assert(t, r.Set(in) != nil)
+	}
+}
+
+func TestUnitFields(t *testing.T) {
+	type unitConf struct {
+		Buffer    ByteSize `conf:"default:4KiB"`
+		Bandwidth Rate
+		Limit     *Rate
+	}
+	prepArgs("--bandwidth", "5MB/s")
+	prepEnv("LIMIT", "100/s")
+	var c unitConf
+	assert(t, Parse(&c) == nil)
+	assert(t, c.Buffer == 4096)
+	assert(t, c.Bandwidth.PerSecond() == 5e6)
+	assert(t, c.Limit.Amount == 100)
+
+	s, err := String(&c)
+	assert(t, err == nil)
+	assert(t, s == "BUFFER=4KiB BANDWIDTH=5MB/s LIMIT=100/s")
+
+	b, err := Marshal(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, string(b) == "BUFFER 4KiB\nBANDWIDTH 5MB/s\nLIMIT 100/s\n")
+
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	name, _ := getTypeAndHelp(&fields[0])
+	assert(t, name == "<size>")
+	name, _ = getTypeAndHelp(&fields[2])
+	assert(t, name == "<rate>")
+}
+
+type backend struct {
+	Host string `conf:"required"`
+	Port int    `conf:"default:80"`
+}
+
+type backendsConf struct {
+	Name     string
+	Backends []backend
+}
+
+func TestStructSlices(t *testing.T) {
+	prepArgs("--backends-1-port", "8080")
+	prepEnv(
+		"BACKENDS_0_HOST", "a",
+		"BACKENDS_1_HOST", "b",
+	)
+	var c backendsConf
+	assert(t, Parse(&c) == nil)
+	assert(t, reflect.DeepEqual(c.Backends, []backend{{"a", 80}, {"b", 8080}}))
+
+	// elements are required to be complete
+	prepArgs("--backends-1-port", "8080")
+	prepEnv("BACKENDS_0_HOST", "a")
+	c = backendsConf{}
+	assert(t, Parse(&c) != nil)
+
+	// gaps between elements are errors, rather than truncating the slice
+	prepArgs()
+	prepEnv("BACKENDS_0_HOST", "a", "BACKENDS_2_HOST", "c")
+	c = backendsConf{}
+	err := Parse(&c)
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: BACKENDS has element 2 but not element 1")
+
+	// elements left over from a previous parse are removed
+	prepEnv()
+	c = backendsConf{Backends: []backend{{"old", 80}}}
+	assert(t, Parse(&c) == nil)
+	assert(t, len(c.Backends) == 0)
+}
+
+func TestStructSlicesJSON(t *testing.T) {
+	testFile, err := ioutil.TempFile("", "conf-test*.json")
+	if err != nil {
+		panic("error creating temp file for test: " + err.Error())
+	}
+	defer os.Remove(testFile.Name())
+	testFile.Write([]byte(`{"backends": [{"host": "a"}, {"host": "b", "port": 8080}]}`))
+	testFile.Close()
+
+	prepArgs()
+	prepEnv()
+	var c backendsConf
+	assert(t, Parse(&c, WithConfigFileFormat(testFile.Name(), FormatJSON)) == nil)
+	assert(t, reflect.DeepEqual(c.Backends, []backend{{"a", 80}, {"b", 8080}}))
+
+	// marshaled JSON reproduces the array
+	b, err := Marshal(&c, FormatJSON)
+	assert(t, err == nil)
+	var got interface{}
+	assert(t, json.Unmarshal(b, &got) == nil)
+	var want interface{}
+	json.Unmarshal([]byte(`{"name": "", "backends": [{"host": "a", "port": 80}, {"host": "b", "port": 8080}]}`), &want)
+	assert(t, reflect.DeepEqual(got, want))
+}
+
+func TestStructSlicesTemplates(t *testing.T) {
+	var c backendsConf
+	fields, err := extractTemplateFields(&c, sliceIndexTemplate)
+	assert(t, err == nil)
+	assert(t, len(fields) == 3)
+	assert(t, fields[1].flagName == "backends-<n>-host")
+	assert(t, fields[2].envName == "BACKENDS_<N>_PORT")
+
+	tmpl, err := ConfigTemplate(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, strings.Contains(tmpl, "#BACKENDS_0_PORT 80\n"))
+
+	b, err := JSONSchema(&c)
+	assert(t, err == nil)
+	var got map[string]interface{}
+	assert(t, json.Unmarshal(b, &got) == nil)
+	backends := got["properties"].(map[string]interface{})["backends"].(map[string]interface{})
+	assert(t, backends["type"] == "array")
+	items := backends["items"].(map[string]interface{})
+	assert(t, reflect.DeepEqual(items["required"], []interface{}{"host"}))
+}
+
+func TestQuotedItems(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		items []string
+	}{
+		{`a,b`, []string{"a", "b"}},
+		{`"a,b",c`, []string{"a,b", "c"}},
+		{`"say ""hi""",`, []string{`say "hi"`, ""}},
+		{`a"b`, []string{`a"b`}},
+	} {
+		items, err := splitList(tc.value, ",")
+		assert(t, err == nil)
+		assert(t, reflect.DeepEqual(items, tc.items))
+		rejoined, err := splitList(joinList(items, ","), ",")
+		assert(t, err == nil)
+		assert(t, reflect.DeepEqual(rejoined, tc.items))
+	}
+	_, err := splitList(`"a,b`, ",")
+	assert(t, err != nil)
+	_, err = splitList(`"a"b,c`, ",")
+	assert(t, err != nil)
+
+	pairs, err := splitMap(`http:http://a:8080,"a:b":"c,d"`, ",", ":")
+	assert(t, err == nil)
+	assert(t, reflect.DeepEqual(pairs, [][2]string{{"http", "http://a:8080"}, {"a:b", "c,d"}}))
+	assert(t, joinMap(pairs, ",", ":") == `"a:b":"c,d",http:http://a:8080`)
+	_, err = splitMap(`a,b:c`, ",", ":")
+	assert(t, err.Error() == `invalid map item: "a"`)
+}
+
+func TestSeparators(t *testing.T) {
+	type sepConf struct {
+		Proxies map[string]string
+		Paths   []string          `conf:"sep:;"`
+		Labels  map[string]string `conf:"sep:;,kvsep:="`
+	}
+	prepArgs(
+		"--proxies", "http:http://a:8080,https:https://b:8443",
+		"--paths", "/a,b;/c",
+		"--labels", `a=1;b="x;y"`,
+	)
+	prepEnv()
+	var c sepConf
+	assert(t, Parse(&c) == nil)
+	assert(t, reflect.DeepEqual(c.Proxies, map[string]string{"http": "http://a:8080", "https": "https://b:8443"}))
+	assert(t, reflect.DeepEqual(c.Paths, []string{"/a,b", "/c"}))
+	assert(t, reflect.DeepEqual(c.Labels, map[string]string{"a": "1", "b": "x;y"}))
+
+	b, err := Marshal(&c, FormatConf)
+	assert(t, err == nil)
+	assert(t, strings.HasSuffix(string(b), "PATHS /a,b;/c\nLABELS a=1;b=\"x;y\"\n"))
+
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	name, _ := getTypeAndHelp(&fields[1])
+	assert(t, name == "<string>;[string...]")
+
+	// commas in tag values are escaped with a backslash
+	type commaConf struct {
+		Labels map[string]string `conf:"sep:;,kvsep:\\,,help:labels\\, as key\\,value pairs"`
+	}
+	prepArgs("--labels", "a,1;b,2")
+	prepEnv()
+	var cc commaConf
+	assert(t, Parse(&cc) == nil)
+	assert(t, reflect.DeepEqual(cc.Labels, map[string]string{"a": "1", "b": "2"}))
+	fields, err = extractFields(nil, &cc)
+	assert(t, err == nil)
+	assert(t, fields[0].options.help == "labels, as key,value pairs")
+
+	type badSepConf struct {
+		Labels map[string]string `conf:"kvsep:\\,"`
+	}
+	_, err = extractFields(nil, &badSepConf{})
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error parsing tags for field Labels: `sep` and `kvsep` must differ, got \",\"")
+}
+
+func TestArraysAndNestedCollections(t *testing.T) {
+	type nestedConf struct {
+		Point  [2]int
+		Matrix [][]int
+		Routes map[string][]string
+	}
+	prepArgs(
+		"--point", "1,2",
+		"--matrix", "1;2,3",
+		"--routes", "api:a;b,web:c",
+	)
+	prepEnv()
+	var c nestedConf
+	assert(t, Parse(&c) == nil)
+	assert(t, c.Point == [2]int{1, 2})
+	assert(t, reflect.DeepEqual(c.Matrix, [][]int{{1, 2}, {3}}))
+	assert(t, reflect.DeepEqual(c.Routes, map[string][]string{"api": {"a", "b"}, "web": {"c"}}))
+
+	// values survive a round trip through each format
+	for _, tc := range []struct {
+		format  Format
+		pattern string
+	}{
+		{FormatConf, "conf-test*.conf"},
+		{FormatJSON, "conf-test*.json"},
+	} {
+		b, err := Marshal(&c, tc.format)
+		assert(t, err == nil)
+		testFile, err := ioutil.TempFile("", tc.pattern)
+		if err != nil {
+			panic("error creating temp file for test: " + err.Error())
+		}
+		defer os.Remove(testFile.Name())
+		testFile.Write(b)
+		testFile.Close()
+
+		prepArgs()
+		var out nestedConf
+		assert(t, Parse(&out, WithConfigFileFormat(testFile.Name(), tc.format)) == nil)
+		assert(t, reflect.DeepEqual(c, out))
+	}
+
+	prepArgs("--point", "1,2,3")
+	c = nestedConf{}
+	err := Parse(&c)
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "expected 2 items, got 3"))
+
+	type nestedSepConf struct {
+		Groups [][]string `conf:"nestedsep:|"`
+	}
+	prepArgs("--groups", "a|b,c")
+	var n nestedSepConf
+	assert(t, Parse(&n) == nil)
+	assert(t, reflect.DeepEqual(n.Groups, [][]string{{"a", "b"}, {"c"}}))
+
+	// the nested separator must differ from the others once they're changed,
+	// but only for fields which nest
+	var flat struct {
+		Items []string `conf:"sep:;"`
+	}
+	_, err = extractFields(nil, &flat)
+	assert(t, err == nil)
+	var clash struct {
+		Groups [][]string `conf:"sep:;"`
+	}
+	_, err = extractFields(nil, &clash)
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error parsing tags for field Groups: `nestedsep` must differ from `sep` and `kvsep`, got \";\"")
+
+	var deep struct {
+		Cube [][][]int
+	}
+	_, err = extractFields(nil, &deep)
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error parsing field Cube: collections nested more than two deep are not supported")
+}
+
+func TestUnsupportedTypeIsError(t *testing.T) {
+	type unsupportedConf struct {
+		Value complex128
+	}
+	prepArgs("--value", "1")
+	prepEnv()
+	var c unsupportedConf
+	err := Parse(&c)
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "unsupported type complex128"))
+}
+
+type mode string
+
+type level string
+
+func (level) Values() []string { return []string{"debug", "info"} }
+
+func TestEnums(t *testing.T) {
+	modes := WithEnum[mode]("fast", "slow")
+	type enumConf struct {
+		Mode   mode `conf:"default:fast"`
+		Level  level
+		Levels []level `conf:"ignorecase"`
+	}
+	prepArgs("--level", "info", "--levels", "DEBUG,Info")
+	prepEnv()
+	var c enumConf
+	assert(t, Parse(&c, modes) == nil)
+	assert(t, c.Mode == "fast")
+	assert(t, c.Level == "info")
+	assert(t, reflect.DeepEqual(c.Levels, []level{"debug", "info"}))
+
+	// matching is case-sensitive unless the field is tagged with `ignorecase`
+	prepArgs("--level", "INFO")
+	c = enumConf{}
+	err := Parse(&c, modes)
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), `"INFO" is not one of debug, info`))
+
+	prepArgs()
+	prepEnv("MODE", "medium")
+	c = enumConf{}
+	err = Parse(&c, modes)
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), `"medium" is not one of fast, slow`))
+
+	// values are only restricted for parses given the option
+	c = enumConf{}
+	assert(t, Parse(&c) == nil)
+	assert(t, c.Mode == "medium")
+
+	var ctx context
+	modes(&ctx)
+	fields, err := extractor{types: ctx.types}.extract(nil, nil, &c)
+	assert(t, err == nil)
+	name, _ := getTypeAndHelp(&fields[0])
+	assert(t, name == "<fast|slow>")
+	var b strings.Builder
+	assert(t, printCompletion(&b, "bash", fields, context{}) == nil)
+	assert(t, strings.Contains(b.String(), "'debug info'"))
+
+	type badDefaultConf struct {
+		Mode mode `conf:"default:medium"`
+	}
+	_, err = extractor{types: ctx.types}.extract(nil, nil, &badDefaultConf{})
+	assert(t, err != nil)
+}
+
+type point struct{ X, Y int }
+
+func TestDecoders(t *testing.T) {
+	pointType := reflect.TypeOf(point{})
+	options := []Option{
+		WithDecoder(pointType, func(s string) (interface{}, error) {
+			var p point
+			_, err := fmt.Sscanf(s, "%dx%d", &p.X, &p.Y)
+			return p, err
+		}),
+		WithEncoder(pointType, func(v interface{}) string {
+			p := v.(point)
+			return fmt.Sprintf("%dx%d", p.X, p.Y)
+		}),
+	}
+	type decoderConf struct {
+		Origin point
+		Corner *point
+		Path   []point
+	}
+	prepArgs("--origin", "1x2", "--corner", "3x4", "--path", "1x1,2x2")
+	prepEnv()
+	var c decoderConf
+	assert(t, Parse(&c, options...) == nil)
+	assert(t, c.Origin == point{1, 2})
+	assert(t, *c.Corner == point{3, 4})
+	assert(t, reflect.DeepEqual(c.Path, []point{{1, 1}, {2, 2}}))
+
+	s, err := String(&c, options...)
+	assert(t, err == nil)
+	assert(t, s == "ORIGIN=1x2 CORNER=3x4 PATH=1x1,2x2")
+	b, err := Marshal(&c, FormatConf, options...)
+	assert(t, err == nil)
+	assert(t, string(b) == "ORIGIN 1x2\nCORNER 3x4\nPATH 1x1,2x2\n")
+
+	// the other exporters share the options
+	c2 := c
+	c2.Origin = point{5, 6}
+	changes, err := Diff(&c, &c2, options...)
+	assert(t, err == nil)
+	assert(t, changes.String() == "ORIGIN: 1x2 -> 5x6\n")
+
+	var logged strings.Builder
+	slog.New(slog.NewJSONHandler(&logged, nil)).Info("config", "cfg", LogValue(&c, options...))
+	assert(t, strings.Contains(logged.String(), `"cfg":{"origin":"1x2","corner":"3x4","path":"1x1,2x2"}`))
+
+	var ctx context
+	for _, option := range options {
+		option(&ctx)
+	}
+	hfs, err := handlerFields(&c, ctx)
+	assert(t, err == nil)
+	assert(t, len(hfs) == 3 && hfs[0].Value == "1x2")
+
+	schema, err := JSONSchema(&c, options...)
+	assert(t, err == nil)
+	assert(t, strings.Contains(string(schema), `"origin": {
+      "type": "string"
+    }`))
+
+	md, err := MarkdownReference(&c, append(options, WithTypeName(pointType, "WxH"))...)
+	assert(t, err == nil)
+	assert(t, strings.Contains(md, "`<WxH>`") && !strings.Contains(md, "origin-x"))
+	htmlRef, err := HTMLReference(&c, options...)
+	assert(t, err == nil)
+	assert(t, !strings.Contains(htmlRef, "origin-x"))
+
+	fields, err := extractor{types: typeConversions{pointType: {}}}.extract(nil, nil, &c)
+	assert(t, err == nil)
+	assert(t, len(fields) == 3)
+	name, _ := getTypeAndHelp(&fields[0])
+	assert(t, name == "<point>")
+	name, _ = getTypeAndHelp(&fields[2])
+	assert(t, name == "<point>,[point...]")
+
+	// decoders must return the type they were registered for
+	prepArgs("--origin", "1x2")
+	c = decoderConf{}
+	err = Parse(&c, WithDecoder(pointType, func(s string) (interface{}, error) {
+		return s, nil
+	}))
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "decoder for conf.point returned string"))
+}
+
+func TestValueSource(t *testing.T) {
+	type valueConf struct {
+		Links  []string
+		Groups [][]string `conf:"nestedsep:|"`
+		Ports  map[string][2]int
+		Ratio  float64
+		Debug  bool
+	}
+	testFile, err := ioutil.TempFile("", "conf-test*.json")
+	if err != nil {
+		panic("error creating temp file for test: " + err.Error())
+	}
+	defer os.Remove(testFile.Name())
+	testFile.Write([]byte(`{
+		"links": ["http://a:80/x,y", "\"quoted\""],
+		"groups": [["a,b", "c|d"], []],
+		"ports": {"web": [80, 443]},
+		"ratio": 0.5,
+		"debug": true
+	}`))
+	testFile.Close()
+
+	prepArgs()
+	prepEnv()
+	var c valueConf
+	assert(t, Parse(&c, WithConfigFileFormat(testFile.Name(), FormatJSON)) == nil)
+	assert(t, reflect.DeepEqual(c.Links, []string{"http://a:80/x,y", `"quoted"`}))
+	assert(t, reflect.DeepEqual(c.Groups, [][]string{{"a,b", "c|d"}, {}}))
+	assert(t, reflect.DeepEqual(c.Ports, map[string][2]int{"web": {80, 443}}))
+	assert(t, c.Ratio == 0.5)
+	assert(t, c.Debug)
+
+	// the string form is still available, and splits back into the same items
+	js, err := newJSONSource(testFile.Name())
+	assert(t, err == nil)
+	value, ok := js.Get([]string{"links"})
+	assert(t, ok)
+	items, err := splitList(value, ",")
+	assert(t, err == nil)
+	assert(t, reflect.DeepEqual(items, c.Links))
+
+	// arrays must match the length of array fields
+	testFile, err = ioutil.TempFile("", "conf-test*.json")
+	if err != nil {
+		panic("error creating temp file for test: " + err.Error())
+	}
+	defer os.Remove(testFile.Name())
+	testFile.Write([]byte(`{"ports": {"web": [80]}}`))
+	testFile.Close()
+	c = valueConf{}
+	err = Parse(&c, WithConfigFileFormat(testFile.Name(), FormatJSON))
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "expected 2 items, got 1"))
+}
+
+// failingSource fails to look up any key after the first
+type failingSource struct {
+	err   error
+	calls int
+}
+
+func (f *failingSource) Get(key []string) (string, bool) {
+	panic("Get called on an ErrorSource")
+}
+
+func (f *failingSource) GetE(ctx gocontext.Context, key []string) (string, bool, error) {
+	f.calls++
+	if f.calls > 1 {
+		return "", false, f.err
+	}
+	return "1", true, nil
+}
+
+func (f *failingSource) String() string {
+	return "remote"
+}
+
+func TestErrorSource(t *testing.T) {
+	prepArgs()
+	prepEnv()
+	errUnavailable := errors.New("unavailable")
+	var c simpleConf
+	err := Parse(&c, WithSource(&failingSource{err: errUnavailable}))
+	assert(t, errors.Is(err, errUnavailable))
+	assert(t, err.Error() == "conf: error reading field TestString from remote: unavailable")
+	assert(t, c.TestInt == 0)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+	err = Parse(&c, WithContext(ctx))
+	assert(t, errors.Is(err, gocontext.Canceled))
+
+	// errors from sources which are also ValueSources are not ignored
+	err = Parse(&c, WithSource(&failingValueSource{failingSource{err: errUnavailable}}))
+	assert(t, errors.Is(err, errUnavailable))
+
+	// and ValueErrorSources provide both values and errors
+	var lc struct {
+		Hosts []string
+		Port  int
+	}
+	vs := &valueErrorSource{values: map[string]interface{}{"hosts": []interface{}{"a,b", "c"}}}
+	assert(t, Parse(&lc, WithSource(vs)) == nil)
+	assert(t, reflect.DeepEqual(lc.Hosts, []string{"a,b", "c"}))
+	vs.err = errUnavailable
+	err = Parse(&lc, WithSource(vs), WithContext(gocontext.Background()))
+	assert(t, errors.Is(err, errUnavailable))
+}
+
+// failingValueSource is a failingSource which also provides structured values
+type failingValueSource struct {
+	failingSource
+}
+
+func (f *failingValueSource) GetValue(key []string) (interface{}, bool) {
+	panic("GetValue called on an ErrorSource")
+}
+
+// valueErrorSource provides structured values, or fails with err if it is set
+type valueErrorSource struct {
+	values map[string]interface{}
+	err    error
+}
+
+func (v *valueErrorSource) Get(key []string) (string, bool) {
+	panic("Get called on a ValueErrorSource")
+}
+
+func (v *valueErrorSource) GetValueE(ctx gocontext.Context, key []string) (interface{}, bool, error) {
+	if v.err != nil {
+		return nil, false, v.err
+	}
+	value, ok := v.values[getFlagName(key)]
+	return value, ok, nil
+}
+
+func TestSourceOrder(t *testing.T) {
+	testFile, err := ioutil.TempFile("", "conf-test")
+	if err != nil {
+		panic("error creating temp file for test: " + err.Error())
+	}
+	defer os.Remove(testFile.Name())
+	testFile.Write([]byte("TEST_INT 1\nTEST_STRING file\n"))
+	testFile.Close()
+
+	prepArgs("--test-bool")
+	prepEnv("TEST_INT", "2")
+	var c simpleConf
+	assert(t, Parse(&c, WithConfigFile(testFile.Name()),
+		WithSourceOrder(SourceEnv, SourceFile, SourceFlags)) == nil)
+	assert(t, c.TestInt == 2)
+	assert(t, c.TestString == "file")
+	assert(t, c.TestBool)
+
+	// sources left out of the order are disabled
+	c = simpleConf{}
+	assert(t, Parse(&c, WithConfigFile(testFile.Name()),
+		WithSourceOrder(SourceFile)) != nil)
+	prepArgs()
+	assert(t, Parse(&c, WithConfigFile(testFile.Name()),
+		WithSourceOrder(SourceFile)) == nil)
+	assert(t, c.TestInt == 1)
+
+	c = simpleConf{}
+	assert(t, Parse(&c, WithConfigFile(testFile.Name()),
+		WithoutSources(SourceFile)) == nil)
+	assert(t, c.TestInt == 2)
+	assert(t, c.TestString == "")
+
+	err = Parse(&c, WithSourceOrder(SourceEnv, SourceEnv))
+	assert(t, err.Error() == "conf: source environment listed more than once")
+
+	// usage shows the effective order, and leaves out disabled sources
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	var b strings.Builder
+	var ctx context
+	WithoutSources(SourceEnv)(&ctx)
+	printUsage(&b, fields, ctx)
+	assert(t, !strings.Contains(b.String(), "$TEST_INT"))
+	assert(t, strings.Contains(b.String(), "SOURCES\n  flags, defaults\n"))
+
+	b.Reset()
+	ctx = context{confFiles: []string{"/etc/test.conf"}}
+	WithSourceOrder(SourceEnv, SourceFile)(&ctx)
+	printUsage(&b, fields, ctx)
+	assert(t, strings.Contains(b.String(), "\n  $TEST_INT <int>"))
+	assert(t, strings.Contains(b.String(), "SOURCES\n  environment, config file, defaults\n"))
+}
+
+func TestLayeredConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := writeTestFile(t, dir, "etc/app.conf", "TEST_INT 1\nTEST_STRING base\n", 0o644)
+	writeTestFile(t, dir, "xdg/app/app.conf", "TEST_INT 2\n", 0o644)
+	writeTestFile(t, dir, "home/app/app.conf", "TEST_BOOL true\n", 0o644)
+	local := writeTestFile(t, dir, "local/app.conf", "TEST_STRING local\n", 0o644)
+
+	prepArgs()
+	prepEnv(
+		"XDG_CONFIG_DIRS", filepath.Join(dir, "xdg")+":relative",
+		"XDG_CONFIG_HOME", filepath.Join(dir, "home"),
+	)
+	options := []Option{
+		WithConfigFile(base),
+		WithXDGConfigFile("app", "app.conf"),
+		WithConfigSearchPath("app.conf", filepath.Join(dir, "missing"), filepath.Join(dir, "local")),
+		WithConfigFileFlag("conf"),
+	}
+	var c simpleConf
+	assert(t, Parse(&c, options...) == nil)
+	assert(t, c.TestInt == 2)
+	assert(t, c.TestString == "local")
+	assert(t, c.TestBool)
+
+	// the flag replaces the files, and may be repeated
+	prepArgs("--conf", local, "--conf", base)
+	c = simpleConf{}
+	assert(t, Parse(&c, options...) == nil)
+	assert(t, c.TestInt == 1)
+	assert(t, c.TestString == "base")
+	assert(t, !c.TestBool)
+
+	// every file is listed in the usage message
+	var ctx context
+	for _, option := range options {
+		option(&ctx)
+	}
+	fields, err := extractFields(nil, &c)
+	assert(t, err == nil)
+	var b strings.Builder
+	printUsage(&b, fields, ctx)
+	assert(t, strings.Contains(b.String(), "FILES\n  "+base+"\n    The base configuration file (overridden by --conf)\n"))
+	assert(t, strings.Contains(b.String(), "  "+local+"\n    Overrides the files above (overridden by --conf)\n"))
+	assert(t, strings.Count(b.String(), "Overrides the files above") == 4)
+	// several files are not shown as a list the flag won't accept
+	assert(t, strings.Contains(b.String(), "repeat to layer files (default: the files under FILES)"))
+	assert(t, !strings.Contains(b.String(), base+","))
+}
+
+func TestIncludesAndDropIns(t *testing.T) {
+	dir := t.TempDir()
+	main := writeTestFile(t, dir, "app.conf", "TEST_INT 1\ninclude extra/*.conf\nTEST_STRING main\n", 0o644)
+	writeTestFile(t, dir, "extra/a.conf", "TEST_INT 2\nTEST_STRING a\n", 0o644)
+	writeTestFile(t, dir, "extra/b.conf", "TEST_INT 3\n", 0o644)
+	writeTestFile(t, dir, "conf.d/10-first.conf", "TEST_BOOL true\nTEST_STRING first\n", 0o644)
+	writeTestFile(t, dir, "conf.d/20-second.conf", "TEST_STRING second\n", 0o644)
+	writeTestFile(t, dir, "conf.d/README", "TEST_STRING readme\n", 0o644)
+
+	prepArgs()
+	prepEnv()
+	var c simpleConf
+	assert(t, Parse(&c, WithConfigFile(main)) == nil)
+	assert(t, c.TestInt == 3)
+	assert(t, c.TestString == "main")
+
+	c = simpleConf{}
+	assert(t, Parse(&c, WithConfigFile(main), WithConfigDropInDir(filepath.Join(dir, "conf.d"))) == nil)
+	assert(t, c.TestInt == 3)
+	assert(t, c.TestString == "second")
+	assert(t, c.TestBool)
+
+	// errors name the file and line of the include
+	cycle := writeTestFile(t, dir, "cycle.conf", "TEST_INT 1\ninclude cycle2.conf\n", 0o644)
+	cycle2 := writeTestFile(t, dir, "cycle2.conf", "include cycle.conf\n", 0o644)
+	err := Parse(&c, WithConfigFile(cycle))
+	assert(t, err != nil)
+	assert(t, err.Error() == cycle2+":1: include cycle: "+cycle+" -> "+cycle2+" -> "+cycle)
+
+	missing := writeTestFile(t, dir, "missing.conf", "\ninclude nothing.conf\n", 0o644)
+	err = Parse(&c, WithConfigFile(missing))
+	assert(t, err != nil)
+	assert(t, strings.HasPrefix(err.Error(), missing+":2: "))
+	assert(t, errors.Is(err, os.ErrNotExist))
+
+	// but a glob matching nothing includes nothing
+	c = simpleConf{}
+	empty := writeTestFile(t, dir, "empty.conf", "TEST_INT 4\ninclude nothing/*.conf\n", 0o644)
+	assert(t, Parse(&c, WithConfigFile(empty)) == nil)
+	assert(t, c.TestInt == 4)
+
+	// the config file flag replaces drop-in directories too
+	prepArgs("--conf", main)
+	c = simpleConf{}
+	assert(t, Parse(&c, WithConfigDropInDir(filepath.Join(dir, "conf.d")), WithConfigFileFlag("conf")) == nil)
+	assert(t, c.TestString == "main")
+	assert(t, !c.TestBool)
+
+	// includes are only supported in the conf format
+	prepArgs()
+	dotenv := writeTestFile(t, dir, "app.env", "TEST_INT=1\ninclude extra/a.conf\n", 0o644)
+	err = Parse(&c, WithConfigFileFormat(dotenv, FormatDotenv))
+	assert(t, err != nil)
+	assert(t, err.Error() == dotenv+":2: expected KEY=value")
+
+	// the format is never guessed from the file name
+	c = simpleConf{}
+	conf := writeTestFile(t, dir, "conf.env", "TEST_INT 5\n", 0o644)
+	assert(t, Parse(&c, WithConfigFile(conf)) == nil)
+	assert(t, c.TestInt == 5)
+}
+
+func TestInterpolation(t *testing.T) {
+	dir := t.TempDir()
+	type interpConf struct {
+		Host    string
+		Port    int    `conf:"default:8080"`
+		URL     string `conf:"default:http://${HOST}:${PORT}/${APP_PATH:-api}"`
+		Data    string
+		Literal string
+	}
+	file := writeTestFile(t, dir, "app.conf", "HOST example.com\nDATA ${USER_HOME}/data\nLITERAL $${HOST}\n", 0o644)
+
+	prepArgs()
+	prepEnv("USER_HOME", "/home/user")
+	var c interpConf
+	assert(t, Parse(&c, WithConfigFile(file), WithInterpolation()) == nil)
+	assert(t, c.URL == "http://example.com:8080/api")
+	assert(t, c.Data == "/home/user/data")
+	assert(t, c.Literal == "${HOST}")
+
+	// values from flags and the environment are used as they are, but may be
+	// referred to
+	prepArgs("--literal", "${HOST}", "--port", "9090")
+	prepEnv("USER_HOME", "/home/user", "APP_PATH", "${HOST}")
+	c = interpConf{}
+	assert(t, Parse(&c, WithConfigFile(file), WithInterpolation()) == nil)
+	assert(t, c.Literal == "${HOST}")
+	assert(t, c.URL == "http://example.com:9090/${HOST}")
+
+	prepArgs()
+	prepEnv()
+	c = interpConf{}
+	err := Parse(&c, WithConfigFile(file), WithInterpolation())
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error resolving field Data: undefined reference ${USER_HOME}")
+
+	type cycleConf struct {
+		A string
+		B string `conf:"default:${A}"`
+	}
+	var cc cycleConf
+	err = Parse(&cc, WithConfigFile(writeTestFile(t, dir, "cycle.conf", "A x${B}\n", 0o644)), WithInterpolation())
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error resolving field A: interpolation cycle: A -> B -> A")
+
+	// without the option, values are used as they are
+	prepEnv("USER_HOME", "/home/user")
+	c = interpConf{}
+	assert(t, Parse(&c, WithConfigFile(writeTestFile(t, dir, "plain.conf", "DATA abc${x\n", 0o644))) == nil)
+	assert(t, c.Data == "abc${x")
+	assert(t, c.URL == "http://${HOST}:${PORT}/${APP_PATH:-api}")
+
+	// as are values from additional sources
+	c = interpConf{}
+	assert(t, Parse(&c, WithInterpolation(), WithSource(&valueErrorSource{values: map[string]interface{}{"data": "${USER_HOME}"}})) == nil)
+	assert(t, c.Data == "${USER_HOME}")
+}
+
+func TestExec(t *testing.T) {
+	dir := t.TempDir()
+	secret := writeTestFile(t, dir, "secret.sh", "#!/bin/sh\necho \"secret for $1 $2\"\n", 0o755)
+	fail := writeTestFile(t, dir, "fail.sh", "#!/bin/sh\necho oops >&2\nexit 3\n", 0o755)
+	slow := writeTestFile(t, dir, "slow.sh", "#!/bin/sh\nexec sleep 5\n", 0o755)
+	type execConf struct {
+		User     string `conf:"default:admin"`
+		Password string `conf:"noprint"`
+		Note     string
+	}
+	file := writeTestFile(t, dir, "app.conf", "PASSWORD !exec "+secret+" ${USER} 'two words'\n", 0o644)
+
+	prepArgs()
+	prepEnv("PATH", initialPath)
+	var c execConf
+	assert(t, Parse(&c, WithConfigFile(file), WithInterpolation(), WithExec(0, secret)) == nil)
+	assert(t, c.Password == "secret for admin two words")
+
+	// without WithExec, values are only interpolated
+	c = execConf{}
+	assert(t, Parse(&c, WithConfigFile(file), WithInterpolation()) == nil)
+	assert(t, c.Password == "!exec "+secret+" admin 'two words'")
+
+	// commands from the environment are never run
+	prepEnv("PATH", initialPath, "PASSWORD", "!exec "+secret)
+	c = execConf{}
+	assert(t, Parse(&c, WithExec(0)) == nil)
+	assert(t, c.Password == "!exec "+secret)
+
+	// nor are those from additional sources
+	prepEnv("PATH", initialPath)
+	c = execConf{}
+	assert(t, Parse(&c, WithExec(0), WithSource(&valueErrorSource{values: map[string]interface{}{"password": "!exec " + secret}})) == nil)
+	assert(t, c.Password == "!exec "+secret)
+
+	prepEnv("PATH", initialPath)
+	err := Parse(&c, WithConfigFile(file), WithExec(0, fail))
+	assert(t, err != nil)
+	assert(t, err.Error() == "conf: error resolving field Password: command "+secret+" is not allowed")
+
+	// referenced values are never split into further arguments
+	prepEnv("PATH", initialPath, "USER", "a 'b  c' --x")
+	c = execConf{}
+	assert(t, Parse(&c, WithConfigFile(file), WithInterpolation(), WithExec(0, secret)) == nil)
+	assert(t, c.Password == "secret for a 'b  c' --x two words")
+
+	// the output of failing commands is only shown for fields which aren't secret
+	prepEnv("PATH", initialPath)
+	file = writeTestFile(t, dir, "fail.conf", "NOTE !exec "+fail+"\n", 0o644)
+	err = Parse(&c, WithConfigFile(file), WithExec(0))
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "exit status 3: oops"))
+
+	file = writeTestFile(t, dir, "failsecret.conf", "PASSWORD !exec "+fail+"\n", 0o644)
+	err = Parse(&c, WithConfigFile(file), WithExec(0))
+	assert(t, err != nil)
+	assert(t, strings.HasSuffix(err.Error(), "exit status 3"))
+	assert(t, !strings.Contains(err.Error(), "oops"))
+
+	file = writeTestFile(t, dir, "slow.conf", "PASSWORD !exec "+slow+"\n", 0o644)
+	start := time.Now()
+	err = Parse(&c, WithConfigFile(file), WithExec(100*time.Millisecond))
+	assert(t, errors.Is(err, gocontext.DeadlineExceeded))
+	assert(t, time.Since(start) < 3*time.Second)
+}
+
+// initialPath is the PATH before any test clears the environment, for tests
+// running commands
+var initialPath = os.Getenv("PATH")
+
+// writeTestFile writes a file for a test at the given path within dir,
+// creating any directories it needs, and returns its full path
+func writeTestFile(t *testing.T, dir, name, contents string, mode os.FileMode) string {
+	path := filepath.Join(dir, name)
+	assert(t, os.MkdirAll(filepath.Dir(path), 0o755) == nil)
+	assert(t, os.WriteFile(path, []byte(contents), mode) == nil)
+	return path
+}
+
 func prepEnv(keyvals ...string) {
 	if len(keyvals)%2 != 0 {
 		panic("prepENV must have even number of keyvals")
@@ -2,7 +2,9 @@ package conf
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -11,21 +13,52 @@ import (
 // token in the line is interpreted as the flag name, and all remaining tokens
 // are interpreted as the value. Any leading hyphens on the flag name are
 // ignored.
+//
+// A line of the form `include <path>` reads another config file at that point,
+// as though its lines appeared in place of the directive. The path is relative
+// to the including file, and may be a glob, in which case every matching file
+// is included, in lexical order. A glob matching no files includes nothing,
+// like an empty drop-in directory, but a plain path must exist. Includes are
+// only supported in this format, not in .env or JSON files.
 type confSource struct {
-	m map[string]string
+	filename string
+	m        map[string]string
 }
 
 func newConfSource(filename string) (*confSource, error) {
-	m := make(map[string]string)
+	l := confLoader{m: make(map[string]string)}
+	if err := l.load(filename); err != nil {
+		return nil, err
+	}
+	return &confSource{
+		filename: filename,
+		m:        l.m,
+	}, nil
+}
+
+// confLoader reads config files and those they include into a single map
+type confLoader struct {
+	m map[string]string
+	// the absolute path of each file being read, for detecting include cycles
+	stack []string
+}
 
+func (l *confLoader) load(filename string) error {
 	cf, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer cf.Close()
 
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
 	s := bufio.NewScanner(cf)
-	for s.Scan() {
+	for lineNum := 1; s.Scan(); lineNum++ {
 		line := strings.TrimSpace(s.Text())
 		if line == "" {
 			continue // skip empties
@@ -50,11 +83,48 @@ func newConfSource(filename string) (*confSource, error) {
 			value = strings.TrimSpace(value[:i])
 		}
 
-		m[name] = value
+		if name == "include" && index >= 0 {
+			if err := l.include(filename, lineNum, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l.m[name] = value
 	}
-	return &confSource{
-		m: m,
-	}, nil
+	return s.Err()
+}
+
+// include reads the files matching the path from an include directive
+func (l *confLoader) include(from string, lineNum int, path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	files := []string{path}
+	if strings.ContainsAny(path, "*?[") {
+		var err error
+		if files, err = filepath.Glob(path); err != nil {
+			return fmt.Errorf("%s:%d: invalid include pattern %q: %s", from, lineNum, path, err)
+		}
+	}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", from, lineNum, err)
+		}
+		for _, f := range l.stack {
+			if f == abs {
+				return fmt.Errorf("%s:%d: include cycle: %s", from, lineNum, strings.Join(append(l.stack, abs), " -> "))
+			}
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("%s:%d: %w", from, lineNum, err)
+		}
+		if err := l.load(file); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Get returns the stringfied value stored at the specified key in the plain
@@ -64,3 +134,11 @@ func (p *confSource) Get(key []string) (string, bool) {
 	value, ok := p.m[k]
 	return value, ok
 }
+
+func (p *confSource) indexes(key []string) []int {
+	return elementIndexes(mapKeys(p.m), getEnvName(key), "_")
+}
+
+func (p *confSource) String() string {
+	return "file " + p.filename
+}
@@ -0,0 +1,111 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change describes a configuration value which differs between two structs.
+// Values are in the same string form accepted by Parse, with those of fields
+// tagged `noprint` redacted and those tagged `secret` masked. Keys which only
+// exist in one of the structs, such as the fields of elements of slices of
+// structs of different lengths, are marked as added or removed.
+type Change struct {
+	Key     string `json:"key"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Added   bool   `json:"added,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// Changes is a list of changed configuration values
+type Changes []Change
+
+// String returns the changes one per line, in the form `KEY: old -> new`, or
+// `KEY: added new` and `KEY: removed old` for keys only in one of the structs
+func (c Changes) String() string {
+	var s strings.Builder
+	for _, change := range c {
+		switch {
+		case change.Added:
+			fmt.Fprintf(&s, "%s: added %s\n", change.Key, change.New)
+		case change.Removed:
+			fmt.Fprintf(&s, "%s: removed %s\n", change.Key, change.Old)
+		default:
+			fmt.Fprintf(&s, "%s: %s -> %s\n", change.Key, change.Old, change.New)
+		}
+	}
+	return s.String()
+}
+
+// Diff compares two conf-tagged structs of the same type, returning the values
+// which differ between them, in field order, followed by any keys added in b.
+// Fields tagged with `secret:hidden` are not compared. The result may be
+// encoded as JSON directly.
+func Diff(a, b interface{}, options ...Option) (Changes, error) {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return nil, errors.New("conf: cannot diff structs of different types")
+	}
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	e := extractor{types: c.types}
+	fieldsA, err := e.extract(nil, nil, a)
+	if err != nil {
+		return nil, err
+	}
+	fieldsB, err := e.extract(nil, nil, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// fields are matched by key, since slices of structs may have different
+	// numbers of elements
+	byKey := make(map[string]field, len(fieldsB))
+	for _, f := range fieldsB {
+		byKey[f.envName] = f
+	}
+
+	var changes Changes
+	for _, fa := range fieldsA {
+		if fa.options.secret.hidden() {
+			continue
+		}
+		fb, ok := byKey[fa.envName]
+		if !ok {
+			changes = append(changes, Change{Key: fa.envName, Old: diffValue(fa), Removed: true})
+			continue
+		}
+		delete(byKey, fa.envName)
+		if reflect.DeepEqual(fa.field.Interface(), fb.field.Interface()) {
+			continue
+		}
+		changes = append(changes, Change{
+			Key: fa.envName,
+			Old: diffValue(fa),
+			New: diffValue(fb),
+		})
+	}
+	for _, fb := range fieldsB {
+		if _, ok := byKey[fb.envName]; ok && !fb.options.secret.hidden() {
+			changes = append(changes, Change{Key: fb.envName, New: diffValue(fb), Added: true})
+		}
+	}
+	return changes, nil
+}
+
+// diffValue returns the value of a field as it should be shown in a Change
+func diffValue(f field) string {
+	value, _ := stringValue(f.field, f.options)
+	switch {
+	case f.options.noprint:
+		return redactedValue
+	case f.options.secret.isSecret():
+		return f.options.secret.apply(value)
+	}
+	return value
+}
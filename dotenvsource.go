@@ -0,0 +1,87 @@
+package conf
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// dotenvSource is a source for .env files. Each line holds a single
+// `KEY=value` pair, optionally preceded by `export`. Values may be
+// double-quoted, in which case Go escape sequences are interpreted, or
+// single-quoted, in which case they are taken literally.
+type dotenvSource struct {
+	filename string
+	m        map[string]string
+}
+
+func newDotenvSource(filename string) (*dotenvSource, error) {
+	m := make(map[string]string)
+
+	df, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer df.Close()
+
+	s := bufio.NewScanner(df)
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue // skip empties and comments
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		index := strings.IndexRune(line, '=')
+		if index < 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", filename, lineNum)
+		}
+		name, value := strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:])
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid quoted value: %s", filename, lineNum, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+		case strings.HasPrefix(value, `'`):
+			end := strings.IndexRune(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: unterminated quoted value", filename, lineNum)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		m[name] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &dotenvSource{
+		filename: filename,
+		m:        m,
+	}, nil
+}
+
+// Get returns the value stored at the specified key in the .env file
+func (d *dotenvSource) Get(key []string) (string, bool) {
+	value, ok := d.m[getEnvName(key)]
+	return value, ok
+}
+
+func (d *dotenvSource) indexes(key []string) []int {
+	return elementIndexes(mapKeys(d.m), getEnvName(key), "_")
+}
+
+func (d *dotenvSource) String() string {
+	return "file " + d.filename
+}
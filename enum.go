@@ -0,0 +1,41 @@
+package conf
+
+import "reflect"
+
+// Enum is implemented by types which only accept a fixed set of values.
+// Fields of these types behave as though they were tagged with `oneof`,
+// listing the values returned by Values, which is called on the zero value.
+type Enum interface {
+	Values() []string
+}
+
+var enumType = reflect.TypeOf((*Enum)(nil)).Elem()
+
+// enumValues returns the values allowed for a field of the given type, or for
+// the items of a slice or array of the type, or nil if it is not an enum
+func (tc typeConversions) enumValues(t reflect.Type) []string {
+	t = derefType(t)
+	if values := tc.typeEnumValues(t); values != nil {
+		return values
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		return tc.typeEnumValues(derefType(t.Elem()))
+	}
+	return nil
+}
+
+func (tc typeConversions) typeEnumValues(t reflect.Type) []string {
+	if values := tc[t].values; values != nil {
+		return values
+	}
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+	switch {
+	case t.Implements(enumType):
+		return reflect.Zero(t).Interface().(Enum).Values()
+	case reflect.PtrTo(t).Implements(enumType):
+		return reflect.New(t).Interface().(Enum).Values()
+	}
+	return nil
+}
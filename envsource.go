@@ -1,10 +1,26 @@
 package conf
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 type envSource struct{}
 
+func (e *envSource) String() string {
+	return "environment"
+}
+
 func (e *envSource) Get(key []string) (string, bool) {
 	varName := getEnvName(key)
 	return os.LookupEnv(varName)
 }
+
+func (e *envSource) indexes(key []string) []int {
+	environ := os.Environ()
+	names := make([]string, len(environ))
+	for i, kv := range environ {
+		names[i], _, _ = strings.Cut(kv, "=")
+	}
+	return elementIndexes(names, getEnvName(key), "_")
+}
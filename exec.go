@@ -0,0 +1,115 @@
+package conf
+
+import (
+	"bytes"
+	gocontext "context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execPrefix marks a value which is produced by running a command, when
+// enabled with WithExec
+const execPrefix = "!exec "
+
+// defaultExecTimeout is how long commands may run if WithExec is given no
+// timeout
+const defaultExecTimeout = 10 * time.Second
+
+// commandRunner runs the commands given by !exec values
+type commandRunner struct {
+	timeout time.Duration
+	// the commands which may be run, or nil if any may be
+	allowed map[string]bool
+}
+
+// run runs a command, returning its output with any trailing newline removed.
+// Unless showStderr is set, the command's standard error is left out of any
+// error, in case it reveals a secret.
+func (r *commandRunner) run(ctx gocontext.Context, args []string, showStderr bool) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("empty command")
+	}
+	if r.allowed != nil && !r.allowed[args[0]] {
+		return "", fmt.Errorf("command %s is not allowed", args[0])
+	}
+
+	timeout := r.timeout
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ctx, cancel := gocontext.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// don't wait for any children holding the output open once killed
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" && showStderr {
+			return "", fmt.Errorf("command %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("command %s: %w", args[0], err)
+	}
+	out := strings.TrimSuffix(stdout.String(), "\n")
+	return strings.TrimSuffix(out, "\r"), nil
+}
+
+// splitCommand splits a command line into arguments at whitespace. Arguments
+// may be quoted with single or double quotes to include whitespace, but no
+// other shell syntax is recognized. References such as ${NAME:-a b} are kept
+// whole, so that they can be expanded within each argument afterwards,
+// without their values being split or unquoted.
+func splitCommand(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "$${"):
+			arg.WriteString("$${")
+			inArg = true
+			i += 2
+		case strings.HasPrefix(line[i:], "${"):
+			end := closingBrace(line, i+2)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ${ reference")
+			}
+			arg.WriteString(line[i : end+1])
+			inArg = true
+			i = end
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -3,6 +3,7 @@ package conf
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -10,6 +11,8 @@ import (
 type field struct {
 	name    string
 	key     []string
+	prefix  []string // the portion of key contributed by enclosing structs
+	path    []string // the name of each enclosing struct, then the field
 	field   reflect.Value
 	options fieldOptions
 	// important for flag parsing or any other source where booleans might be
@@ -18,6 +21,8 @@ type field struct {
 	// for usage
 	flagName string
 	envName  string
+	// the name of the source the value was read from, once processed
+	source string
 }
 
 type fieldOptions struct {
@@ -27,10 +32,69 @@ type fieldOptions struct {
 	defaultStr string
 	noprint    bool
 	required   bool
+	oneof      []string
+	secret     mask
+	layout     string
+	sep        string
+	kvsep      string
+	nestedsep  string
+	ignorecase bool
+	// conversions for types registered with options
+	types typeConversions
 }
 
+// sliceIndexTemplate stands in for the index of slices of structs when
+// documenting their fields
+const sliceIndexTemplate = "<n>"
+
 // extractFields uses reflection to examine the struct and generate the keys
 func extractFields(prefix []string, target interface{}) ([]field, error) {
+	return extractor{}.extract(prefix, nil, target)
+}
+
+// extractTemplateFields extracts fields for documentation. Rather than one set
+// of fields per element, slices of structs produce a single set of fields, with
+// index in place of the element index.
+func extractTemplateFields(target interface{}, index string) ([]field, error) {
+	return extractor{template: index}.extract(nil, nil, target)
+}
+
+// extractor controls how fields are extracted from slices of structs. Each
+// element's fields are keyed by the slice's key, followed by the element's
+// index, so that the host of the first of a slice of backends has the key
+// BACKENDS_0_HOST.
+type extractor struct {
+	// size returns the number of elements a slice should have. If it is nil,
+	// the slice is left as it is.
+	size func(key []string, slice reflect.Value) (int, error)
+	// if set, fields are extracted from a single new element, with template in
+	// place of its index
+	template string
+	// conversions for types registered with options, which are treated as
+	// fields rather than drilled into
+	types typeConversions
+}
+
+// collectionDepth returns the number of levels of slices, arrays and maps in a
+// type, not counting types which decode themselves
+func (e extractor) collectionDepth(t reflect.Type) int {
+	depth := 0
+	for {
+		t = derefType(t)
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			if e.types.decodesItself(t) {
+				return depth
+			}
+			depth++
+			t = t.Elem()
+		default:
+			return depth
+		}
+	}
+}
+
+func (e extractor) extract(prefix []string, path []string, target interface{}) ([]field, error) {
 	if prefix == nil {
 		prefix = []string{}
 	}
@@ -61,7 +125,8 @@ func extractFields(prefix []string, target interface{}) ([]field, error) {
 
 		fieldName := structField.Name
 		// break name into constituent pieces via CamelCase parser
-		fieldKey := append(prefix, camelSplit(fieldName)...)
+		fieldKey := appendKey(prefix, camelSplit(fieldName)...)
+		fieldPath := appendKey(path, getFlagName(camelSplit(fieldName)))
 
 		// get and options
 		fieldOpts, err := parseTag(fieldTags)
@@ -69,11 +134,15 @@ func extractFields(prefix []string, target interface{}) ([]field, error) {
 			return nil, fmt.Errorf("conf: error parsing tags for field %s: %s", fieldName, err)
 		}
 
-		// Drill down through pointers until we bottom out at type or nil
+		// Drill down through pointers until we bottom out at type or nil, or at
+		// a pointer to a type which is only supported through pointers
 		for f.Kind() == reflect.Ptr {
+			if bt, ok := e.types.lookup(f.Type()); ok && bt.pointer {
+				break
+			}
 			if f.IsNil() {
-				// not a struct, leave it alone
-				if f.Type().Elem().Kind() != reflect.Struct {
+				// not a struct (or one that deserializes itself), leave it alone
+				if f.Type().Elem().Kind() != reflect.Struct || e.types.decodesItself(f.Type().Elem()) {
 					break
 				}
 				// It is a struct, zero it out
@@ -82,28 +151,62 @@ func extractFields(prefix []string, target interface{}) ([]field, error) {
 			f = f.Elem()
 		}
 
-		// if we've found a struct, drill down, appending fields as we go
-		if f.Kind() == reflect.Struct {
-			// skip if it can deserialize itself
-			if setterFrom(f) == nil && textUnmarshaler(f) == nil && binaryUnmarshaler(f) == nil {
-				// prefix for any subkeys is the fieldKey, unless it's anonymous, then it's just the prefix so far
-				innerPrefix := fieldKey
-				if structField.Anonymous {
-					innerPrefix = prefix
-				}
+		// if we've found a slice of structs, drill down into each element
+		if e.isStructSlice(f.Type()) {
+			innerFields, err := e.extractSlice(fieldKey, fieldPath, f)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, innerFields...)
+			continue
+		}
 
-				embeddedPtr := f.Addr().Interface()
-				innerFields, err := extractFields(innerPrefix, embeddedPtr)
-				if err != nil {
-					return nil, err
-				}
-				fields = append(fields, innerFields...)
+		// if we've found a struct, drill down, appending fields as we go, unless
+		// it can deserialize itself, in which case it's treated like any other
+		// field
+		if f.Kind() == reflect.Struct && !e.types.decodesItself(f.Type()) {
+			// prefix for any subkeys is the fieldKey, unless it's anonymous, then it's just the prefix so far
+			innerPrefix, innerPath := fieldKey, fieldPath
+			if structField.Anonymous {
+				innerPrefix, innerPath = prefix, path
 			}
+
+			embeddedPtr := f.Addr().Interface()
+			innerFields, err := e.extract(innerPrefix, innerPath, embeddedPtr)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, innerFields...)
 		} else {
+			// enum types are restricted to their values, unless the tag says
+			// otherwise
+			if len(fieldOpts.oneof) == 0 {
+				fieldOpts.oneof = e.types.enumValues(f.Type())
+				if fieldOpts.defaultStr != "" && !fieldOpts.allows(fieldOpts.defaultStr) {
+					return nil, fmt.Errorf("conf: error parsing tags for field %s: default value %q is not one of %s", fieldName, fieldOpts.defaultStr, strings.Join(fieldOpts.oneof, ", "))
+				}
+			}
+			if bt, ok := e.types.lookup(f.Type()); ok && bt.pointer && f.Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("conf: error parsing field %s: only *%s is supported", fieldName, f.Type())
+			}
+			// collections have a separator for their items and one for those
+			// of the collections inside them, so they can only nest once
+			switch depth := e.collectionDepth(f.Type()); {
+			case depth > 2:
+				return nil, fmt.Errorf("conf: error parsing field %s: collections nested more than two deep are not supported", fieldName)
+			case depth == 2 && (fieldOpts.nestedSeparator() == fieldOpts.separator() || fieldOpts.nestedSeparator() == fieldOpts.kvSeparator()):
+				return nil, fmt.Errorf("conf: error parsing tags for field %s: `nestedsep` must differ from `sep` and `kvsep`, got %q", fieldName, fieldOpts.nestedSeparator())
+			}
+			if fieldOpts.layout != "" && !holdsTime(f.Type()) {
+				return nil, fmt.Errorf("conf: error parsing tags for field %s: `layout` is only supported for time.Time fields", fieldName)
+			}
+			fieldOpts.types = e.types
 			// append the field
 			fields = append(fields, field{
 				name:      fieldName,
 				key:       fieldKey,
+				prefix:    prefix,
+				path:      fieldPath,
 				flagName:  getFlagName(fieldKey),
 				envName:   getEnvName(fieldKey),
 				field:     f,
@@ -115,12 +218,104 @@ func extractFields(prefix []string, target interface{}) ([]field, error) {
 	return fields, nil
 }
 
+// extractSlice extracts the fields of each element in a slice of structs
+func (e extractor) extractSlice(key []string, path []string, slice reflect.Value) ([]field, error) {
+	elemType := slice.Type().Elem()
+	if e.template != "" {
+		elem := reflect.New(derefType(elemType))
+		return e.extract(appendKey(key, e.template), appendKey(path, e.template), elem.Interface())
+	}
+
+	if e.size != nil {
+		n, err := e.size(key, slice)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// don't keep elements from a previous parse
+			slice.Set(reflect.Zero(slice.Type()))
+		} else {
+			sized := reflect.MakeSlice(slice.Type(), n, n)
+			reflect.Copy(sized, slice)
+			slice.Set(sized)
+		}
+	}
+
+	fields := []field{}
+	for i := 0; i < slice.Len(); i++ {
+		elem := slice.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elemType.Elem()))
+			}
+			elem = elem.Elem()
+		}
+		index := strconv.Itoa(i)
+		elemFields, err := e.extract(appendKey(key, index), appendKey(path, index), elem.Addr().Interface())
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, elemFields...)
+	}
+	return fields, nil
+}
+
+// isTemplateField reports whether a field was extracted from a template
+// element of a slice of structs
+func isTemplateField(f field) bool {
+	for _, k := range f.key {
+		if k == sliceIndexTemplate {
+			return true
+		}
+	}
+	return false
+}
+
+// isStructSlice reports whether a type is a slice of structs (or pointers to
+// structs) which must be drilled into, rather than converted directly
+func (e extractor) isStructSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct && !e.types.decodesItself(elem)
+}
+
+// appendKey appends to a key without modifying its backing array
+func appendKey(key []string, parts ...string) []string {
+	return append(key[:len(key):len(key)], parts...)
+}
+
+// splitTag splits a tag into its comma-separated options. A comma preceded by
+// a backslash, as in `conf:"sep:\\,"`, is part of an option's value rather than
+// a separator, and a doubled backslash is a single backslash.
+func splitTag(tagStr string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(tagStr); i++ {
+		switch {
+		case tagStr[i] == '\\' && i+1 < len(tagStr) && (tagStr[i+1] == ',' || tagStr[i+1] == '\\'):
+			i++
+			part.WriteByte(tagStr[i])
+		case tagStr[i] == ',':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(tagStr[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
 func parseTag(tagStr string) (fieldOptions, error) {
 	f := fieldOptions{}
 	if tagStr == "" {
 		return f, nil
 	}
-	tagParts := strings.Split(tagStr, ",")
+	tagParts := splitTag(tagStr)
 	for _, tagPart := range tagParts {
 		vals := strings.SplitN(tagPart, ":", 2)
 		tagProp := vals[0]
@@ -131,6 +326,10 @@ func parseTag(tagStr string) (fieldOptions, error) {
 				f.noprint = true
 			case "required":
 				f.required = true
+			case "secret":
+				f.secret = mask{strategy: maskStars}
+			case "ignorecase":
+				f.ignorecase = true
 			}
 		case 2:
 			tagPropVal := strings.TrimSpace(vals[1])
@@ -147,6 +346,22 @@ func parseTag(tagStr string) (fieldOptions, error) {
 				f.defaultStr = tagPropVal
 			case "help":
 				f.help = tagPropVal
+			case "oneof":
+				f.oneof = strings.Fields(tagPropVal)
+			case "layout":
+				f.layout = tagPropVal
+			case "sep":
+				f.sep = tagPropVal
+			case "kvsep":
+				f.kvsep = tagPropVal
+			case "nestedsep":
+				f.nestedsep = tagPropVal
+			case "secret":
+				m, err := parseMask(tagPropVal)
+				if err != nil {
+					return f, err
+				}
+				f.secret = m
 			}
 		}
 	}
@@ -155,6 +370,72 @@ func parseTag(tagStr string) (fieldOptions, error) {
 	switch {
 	case f.required && f.defaultStr != "":
 		return f, fmt.Errorf("cannot set both `required` and `default`")
+	case strings.Contains(f.sep+f.kvsep+f.nestedsep, `"`):
+		return f, fmt.Errorf("separators cannot contain double quotes")
+	case f.separator() == f.kvSeparator():
+		return f, fmt.Errorf("`sep` and `kvsep` must differ, got %q", f.separator())
+	case f.nestedsep != "" && (f.nestedsep == f.separator() || f.nestedsep == f.kvSeparator()):
+		return f, fmt.Errorf("`nestedsep` must differ from `sep` and `kvsep`, got %q", f.nestedsep)
+	case f.defaultStr != "" && len(f.oneof) > 0 && !f.allows(f.defaultStr):
+		return f, fmt.Errorf("default value %q is not one of %s", f.defaultStr, strings.Join(f.oneof, ", "))
 	}
 	return f, nil
 }
+
+// errorValue returns the value as it should be displayed in error messages,
+// masking it if the field is a secret or not printed
+func (f field) errorValue(value string) string {
+	if f.options.noprint || f.options.secret.hidden() {
+		return maskedStars
+	}
+	return f.options.secret.apply(value)
+}
+
+// isSecret reports whether the field's value must not be shown
+func (f field) isSecret() bool {
+	return f.options.noprint || f.options.secret.isSecret()
+}
+
+// processError returns an error for a failure to assign the value, masking
+// the value, and leaving out the details, which may include it, for secrets
+func (f field) processError(value string, err error) *processError {
+	if f.isSecret() {
+		err = errSecretDetails
+	}
+	return &processError{
+		fieldName: f.name,
+		typeName:  f.field.Type().String(),
+		value:     f.errorValue(value),
+		err:       err,
+	}
+}
+
+// displayDefault returns the default value as it should be displayed in
+// documentation, masking it if the field is a secret
+func (f field) displayDefault() string {
+	if f.options.secret.hidden() {
+		return ""
+	}
+	return f.options.secret.apply(f.options.defaultStr)
+}
+
+// allows reports whether value is permitted by the field's `oneof` constraint.
+// Fields without a constraint allow any value.
+func (f fieldOptions) allows(value string) bool {
+	if len(f.oneof) == 0 {
+		return true
+	}
+	_, ok := f.choice(value)
+	return ok
+}
+
+// choice returns the value from the field's `oneof` constraint matching
+// value, ignoring case if the field is tagged with `ignorecase`
+func (f fieldOptions) choice(value string) (string, bool) {
+	for _, o := range f.oneof {
+		if value == o || (f.ignorecase && strings.EqualFold(value, o)) {
+			return o, true
+		}
+	}
+	return "", false
+}
@@ -4,27 +4,44 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 )
 
 type flagSource struct {
 	found map[string]string
+	// every value given for the config file flag, which may be repeated
+	confFiles []string
 }
 
-var errHelpWanted = errors.New("help wanted")
+var (
+	errHelpWanted     = errors.New("help wanted")
+	errTemplateWanted = errors.New("config template wanted")
+)
+
+// completionFlag is the hidden flag used to request a shell completion script
+const completionFlag = "completion"
+
+// errCompletionWanted is returned when a shell completion script is requested
+type errCompletionWanted struct {
+	shell string
+}
+
+func (e *errCompletionWanted) Error() string {
+	return fmt.Sprintf("completion wanted for shell %q", e.shell)
+}
 
 // TODO?: make missing flags optionally throw error
-func newFlagSource(fields []field, exempt []string) (*flagSource, []string, error) {
+func newFlagSource(fields []field, c context) (*flagSource, []string, error) {
 	found := make(map[string]string, len(fields))
+	var confFiles []string
 	expected := make(map[string]*field, len(fields))
 	shorts := make(map[string]string, len(fields))
-	exemptFlags := make(map[string]struct{}, len(exempt))
+	exemptFlags := make(map[string]struct{}, 1)
 
 	// some flags are special, like for specifying a config file flag, which
 	// we definitely want to inspect, but don't represent field data
-	for _, exemptFlag := range exempt {
-		if exemptFlag != "" {
-			exemptFlags[exemptFlag] = struct{}{}
-		}
+	if c.confFlag != "" {
+		exemptFlags[c.confFlag] = struct{}{}
 	}
 
 	for i, field := range fields {
@@ -78,10 +95,32 @@ func newFlagSource(fields []field, exempt []string) (*flagSource, []string, erro
 				return nil, nil, errHelpWanted
 			}
 
+			if c.templateFlag != "" && name == c.templateFlag && expected[name] == nil {
+				return nil, nil, errTemplateWanted
+			}
+
+			if name == completionFlag && expected[name] == nil {
+				if !hasValue {
+					if len(args) == 0 {
+						return nil, nil, fmt.Errorf("flag needs an argument: -%s", name)
+					}
+					value = args[0]
+				}
+				return nil, nil, &errCompletionWanted{shell: value}
+			}
+
 			if long, ok := shorts[name]; ok {
 				name = long
 			}
 
+			// flags for the elements of slices of structs are expected under
+			// their template name
+			if expected[name] == nil {
+				if f, ok := expected[templateFlagName(name)]; ok {
+					expected[name] = f
+				}
+			}
+
 			if expected[name] == nil {
 				if _, ok := exemptFlags[name]; !ok {
 					return nil, nil, fmt.Errorf("flag provided but not defined: -%s", name)
@@ -99,7 +138,7 @@ func newFlagSource(fields []field, exempt []string) (*flagSource, []string, erro
 					// we wanted a value but found the end or another flag. The only time this is okay
 					// is if this is a boolean flag, in which case `-flag` is okay, because it is assumed
 					// to be the same as `-flag true`
-					if expected[name].boolField {
+					if f := expected[name]; f != nil && f.boolField {
 						value = "true"
 					} else {
 						return nil, nil, fmt.Errorf("flag needs an argument: -%s", name)
@@ -107,20 +146,50 @@ func newFlagSource(fields []field, exempt []string) (*flagSource, []string, erro
 				}
 			}
 			found[name] = value
+			if c.confFlag != "" && name == c.confFlag {
+				confFiles = append(confFiles, value)
+			}
 		}
 	}
 
 	return &flagSource{
-		found: found,
+		found:     found,
+		confFiles: confFiles,
 	}, args, nil
 }
 
+// templateFlagName replaces any numeric parts of a flag name with the slice
+// index template, so that flags such as --backends-0-host can be matched with
+// the template field --backends-<n>-host
+func templateFlagName(name string) string {
+	parts := strings.Split(name, "-")
+	for i, part := range parts {
+		if part != "" && strings.Trim(part, "0123456789") == "" {
+			parts[i] = sliceIndexTemplate
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// configFiles returns the files given with the config file flag, in order
+func (f *flagSource) configFiles() []string {
+	return f.confFiles
+}
+
+func (f *flagSource) String() string {
+	return "flags"
+}
+
 func (f *flagSource) Get(key []string) (string, bool) {
 	flagStr := getFlagName(key)
 	val, found := f.found[flagStr]
 	return val, found
 }
 
+func (f *flagSource) indexes(key []string) []int {
+	return elementIndexes(mapKeys(f.found), getFlagName(key), "-")
+}
+
 /*
 Portions Copyright (c) 2009 The Go Authors. All rights reserved.
 
@@ -0,0 +1,29 @@
+package conf
+
+// Format identifies a configuration file format supported for generation and
+// export.
+type Format int
+
+const (
+	// FormatConf is the simple format read by WithConfigFile, where each line
+	// holds a key and its value, separated by whitespace. Config files are read
+	// in this format unless given another with WithConfigFileFormat.
+	FormatConf Format = iota
+	// FormatDotenv is the `KEY=value` format used by .env files. Values may be
+	// double-quoted, using Go escape sequences, or single-quoted literally.
+	FormatDotenv
+	// FormatJSON is a JSON object, with nested structs represented as nested
+	// objects keyed by the flag-style name of each struct field.
+	FormatJSON
+)
+
+// newFileSource creates a source for the config file, based on its format
+func newFileSource(filename string, format Format) (Source, error) {
+	switch format {
+	case FormatJSON:
+		return newJSONSource(filename)
+	case FormatDotenv:
+		return newDotenvSource(filename)
+	}
+	return newConfSource(filename)
+}
@@ -0,0 +1,91 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/tabwriter"
+)
+
+// handlerField describes a single field served by Handler
+type handlerField struct {
+	Key     string      `json:"key"`
+	Flag    string      `json:"flag"`
+	Value   interface{} `json:"value"`
+	Default string      `json:"default,omitempty"`
+	Source  string      `json:"source,omitempty"`
+}
+
+// Handler returns an http.Handler that serves the current values of the
+// provided conf-tagged struct, along with each field's default and, if given
+// the same WithProvenance option as Parse, the source its value was read from.
+// The configuration is served as JSON, unless the request has a `format=text`
+// query parameter. Fields tagged with `noprint` are redacted, and those tagged
+// with `secret` are masked. Since the struct is inspected on every request,
+// the handler always reflects the latest call to Parse.
+func Handler(v interface{}, options ...Option) http.Handler {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields, err := handlerFields(v, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE\tDEFAULT")
+			for _, f := range fields {
+				value, _ := json.Marshal(f.Value)
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Key, value, f.Source, f.Default)
+			}
+			tw.Flush()
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Fields []handlerField `json:"fields"`
+		}{fields})
+	})
+}
+
+func handlerFields(v interface{}, c context) ([]handlerField, error) {
+	fields, err := extractor{types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]handlerField, 0, len(fields))
+	for _, f := range fields {
+		if f.options.secret.hidden() {
+			continue
+		}
+		hf := handlerField{
+			Key:     f.envName,
+			Flag:    f.flagName,
+			Default: f.displayDefault(),
+		}
+		if c.provenance != nil {
+			hf.Source = c.provenance.Source(f.envName)
+		}
+		switch {
+		case f.options.noprint:
+			hf.Value = redactedValue
+			hf.Default = ""
+		case f.options.secret.isSecret():
+			value, _ := stringValue(f.field, f.options)
+			hf.Value = f.options.secret.apply(value)
+		default:
+			hf.Value = jsonValue(f.field, f.options)
+		}
+		out = append(out, hf)
+	}
+	return out, nil
+}
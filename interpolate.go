@@ -0,0 +1,205 @@
+package conf
+
+import (
+	gocontext "context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// rawValue is the value of a field as found in a source, before interpolation
+type rawValue struct {
+	value string
+	typed interface{}
+	// whether the value may refer to other keys and environment variables
+	interpolate bool
+	// whether the value may be produced by a command
+	exec bool
+	// whether the value is a secret, whose command's errors must not be shown
+	secret bool
+}
+
+// interpolator expands references of the form ${NAME} in values. NAME is the
+// environment-style name of another config key, or failing that, of an
+// environment variable. ${NAME:-fallback} uses fallback if NAME is unset or
+// empty, and $${ is a literal ${. If commands are enabled, values of the form
+// "!exec command args..." are replaced by the output of the command, after
+// expanding any references in the command line if interpolation is enabled.
+type interpolator struct {
+	ctx gocontext.Context
+	// runs commands, or nil if they are not enabled
+	runner *commandRunner
+	// the raw values of the fields, by environment name
+	values map[string]rawValue
+	// the expanded values of the fields, by environment name
+	expanded map[string]string
+	// the names being expanded, for detecting cycles
+	stack []string
+}
+
+func newInterpolator(ctx gocontext.Context, runner *commandRunner, fields []field, values []rawValue) *interpolator {
+	in := &interpolator{
+		ctx:      ctx,
+		runner:   runner,
+		values:   make(map[string]rawValue, len(fields)),
+		expanded: make(map[string]string),
+	}
+	for i, f := range fields {
+		in.values[f.envName] = values[i]
+	}
+	return in
+}
+
+// lookup returns the expanded value of a config key or, if there is no such
+// key, of an environment variable
+func (in *interpolator) lookup(name string) (string, bool, error) {
+	v, ok := in.values[name]
+	if !ok {
+		value, ok := os.LookupEnv(name)
+		return value, ok, nil
+	}
+	if !v.interpolate && !v.exec {
+		return v.value, true, nil
+	}
+	if value, ok := in.expanded[name]; ok {
+		return value, true, nil
+	}
+	for _, n := range in.stack {
+		if n == name {
+			return "", false, fmt.Errorf("interpolation cycle: %s", strings.Join(append(in.stack, name), " -> "))
+		}
+	}
+	in.stack = append(in.stack, name)
+	var value string
+	var err error
+	line, isCommand := strings.CutPrefix(v.value, execPrefix)
+	switch {
+	case isCommand && v.exec:
+		value, err = in.command(line, v)
+	case v.interpolate:
+		value, err = in.expand(v.value)
+	default:
+		value = v.value
+	}
+	in.stack = in.stack[:len(in.stack)-1]
+	if err != nil {
+		return "", false, err
+	}
+	in.expanded[name] = value
+	return value, true, nil
+}
+
+// command runs the command line of a value, expanding references in each of
+// its arguments only once it has been split, so that their values can't add
+// arguments
+func (in *interpolator) command(line string, v rawValue) (string, error) {
+	args, err := splitCommand(line)
+	if err != nil {
+		return "", err
+	}
+	if v.interpolate {
+		for i, arg := range args {
+			if args[i], err = in.expand(arg); err != nil {
+				return "", err
+			}
+		}
+	}
+	return in.runner.run(in.ctx, args, !v.secret)
+}
+
+// expand replaces every reference in s with its value
+func (in *interpolator) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			out.WriteString("${")
+			i += 3
+		case strings.HasPrefix(s[i:], "${"):
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ reference")
+			}
+			value, err := in.reference(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i = end + 1
+		default:
+			out.WriteByte(s[i])
+			i++
+		}
+	}
+	return out.String(), nil
+}
+
+// reference returns the value of the contents of a reference
+func (in *interpolator) reference(ref string) (string, error) {
+	name, fallback, hasFallback := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty reference ${%s}", ref)
+	}
+	value, ok, err := in.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case hasFallback && value == "":
+		return in.expand(fallback)
+	case !ok:
+		return "", fmt.Errorf("undefined reference ${%s}", name)
+	}
+	return value, nil
+}
+
+// closingBrace returns the index of the brace closing a reference whose
+// contents start at start, allowing for references nested in fallbacks, or -1
+// if there is none
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}' && depth == 0:
+			return i
+		case s[i] == '}':
+			depth--
+		}
+	}
+	return -1
+}
+
+// expandTyped expands references in the strings of a structured value
+func (in *interpolator) expandTyped(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return in.expand(val)
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			expanded, err := in.expandTyped(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = expanded
+		}
+		return items, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			expanded, err := in.expandTyped(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = expanded
+		}
+		return obj, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,161 @@
+package conf
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// jsonSchemaDialect is the JSON Schema draft generated schemas conform to
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	setterType            = reflect.TypeOf((*Setter)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	durationType          = reflect.TypeOf(time.Duration(0))
+)
+
+// JSONSchema returns a JSON Schema (draft 2020-12) describing the provided
+// conf-tagged struct. Nested structs are represented as nested objects, keyed
+// by the flag-style name of each struct field. Types are derived from the
+// field types, and defaults, descriptions, required fields and `oneof`
+// constraints from the field tags. Slices of structs are represented as arrays
+// of objects. Types registered with options are represented as strings.
+func JSONSchema(v interface{}, options ...Option) ([]byte, error) {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{template: sliceIndexTemplate, types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return nil, err
+	}
+
+	root := newSchemaObject()
+	root["$schema"] = jsonSchemaDialect
+	for _, f := range fields {
+		// find or create the object containing the field
+		obj := root
+		parents := f.path[:len(f.path)-1]
+		for i := 0; i < len(parents); i++ {
+			props := obj["properties"].(map[string]interface{})
+			name := parents[i]
+			// slices of structs are arrays, whose items hold the fields
+			if i+1 < len(parents) && parents[i+1] == sliceIndexTemplate {
+				array, ok := props[name].(map[string]interface{})
+				if !ok {
+					array = map[string]interface{}{"type": "array", "items": newSchemaObject()}
+					props[name] = array
+				}
+				obj = array["items"].(map[string]interface{})
+				i++
+				continue
+			}
+			child, ok := props[name].(map[string]interface{})
+			if !ok {
+				child = newSchemaObject()
+				props[name] = child
+			}
+			obj = child
+		}
+
+		t := f.field.Type()
+		prop := schemaForType(t, c.types)
+		if _, help := getTypeAndHelp(&f); help != "" {
+			prop["description"] = help
+		}
+		if f.options.defaultStr != "" && !f.options.secret.isSecret() {
+			prop["default"] = schemaValue(f.options.defaultStr, t, f.options)
+		}
+		if len(f.options.oneof) > 0 {
+			// for collections, the constraint applies to each item
+			target, et := prop, t
+			if items, ok := prop["items"].(map[string]interface{}); ok {
+				target, et = items, derefType(t).Elem()
+			}
+			enum := make([]interface{}, len(f.options.oneof))
+			for i, o := range f.options.oneof {
+				enum[i] = schemaValue(o, et, f.options)
+			}
+			target["enum"] = enum
+		}
+
+		name := f.path[len(f.path)-1]
+		obj["properties"].(map[string]interface{})[name] = prop
+		if f.options.required {
+			required, _ := obj["required"].([]string)
+			obj["required"] = append(required, name)
+		}
+	}
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func newSchemaObject() map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{},
+		"additionalProperties": false,
+	}
+}
+
+// schemaForType returns the schema for a field of the given type
+func schemaForType(t reflect.Type, types typeConversions) map[string]interface{} {
+	t = derefType(t)
+	// types which deserialize themselves are always represented as strings
+	if types.decodesItself(t) || t == durationType {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem(), types)}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem(), types), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaForType(t.Elem(), types)}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// schemaValue converts a string value from a tag into the representation
+// used by the schema for the given type. If it cannot be converted, the
+// original string is returned.
+func schemaValue(value string, t reflect.Type, opts fieldOptions) interface{} {
+	t = derefType(t)
+	if schemaForType(t, opts.types)["type"] == "string" {
+		return value
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		vals, err := splitList(value, opts.separator())
+		if err != nil {
+			return value
+		}
+		items := make([]interface{}, len(vals))
+		for i, val := range vals {
+			items[i] = schemaValue(val, t.Elem(), opts.nested())
+		}
+		return items
+	}
+	v := reflect.New(t).Elem()
+	if err := processField(value, v, opts); err != nil {
+		return value
+	}
+	return v.Interface()
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
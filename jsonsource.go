@@ -0,0 +1,90 @@
+package conf
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+// jsonSource is a source for JSON config files. The file must contain a single
+// object, and nested structs are represented as nested objects keyed by the
+// flag-style name of each struct field. Arrays and objects at the leaves are
+// assigned directly to slices and maps, or, when read as strings, flattened
+// into the same comma-separated form used by the other sources, quoting items
+// as needed.
+type jsonSource struct {
+	filename string
+	m        map[string]interface{}
+}
+
+func newJSONSource(filename string) (*jsonSource, error) {
+	jf, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer jf.Close()
+
+	var root map[string]interface{}
+	dec := json.NewDecoder(jf)
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	flattenJSON(nil, root, m)
+	return &jsonSource{
+		filename: filename,
+		m:        m,
+	}, nil
+}
+
+// flattenJSON stores every value in the tree by its flag-style name. Objects
+// are stored both as their individual members and, for map fields, as a
+// whole. Arrays are stored both as their individual elements and, for slice
+// fields, as a whole.
+func flattenJSON(prefix []string, v interface{}, m map[string]interface{}) {
+	switch val := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, child := range val {
+			flattenJSON(appendKey(prefix, k), child, m)
+		}
+		if len(prefix) > 0 {
+			m[getFlagName(prefix)] = val
+		}
+	case []interface{}:
+		// arrays are stored both as a whole and by the index of each element,
+		// for slices of structs
+		for i, child := range val {
+			flattenJSON(appendKey(prefix, strconv.Itoa(i)), child, m)
+		}
+		m[getFlagName(prefix)] = val
+	default:
+		m[getFlagName(prefix)] = val
+	}
+}
+
+// Get returns the stringified value stored at the specified key in the JSON
+// file
+func (j *jsonSource) Get(key []string) (string, bool) {
+	value, ok := j.m[getFlagName(key)]
+	if !ok {
+		return "", false
+	}
+	return valueString(value, fieldOptions{}), true
+}
+
+// GetValue returns the value stored at the specified key in the JSON file
+func (j *jsonSource) GetValue(key []string) (interface{}, bool) {
+	value, ok := j.m[getFlagName(key)]
+	return value, ok
+}
+
+func (j *jsonSource) indexes(key []string) []int {
+	return elementIndexes(mapKeys(j.m), getFlagName(key), "-")
+}
+
+func (j *jsonSource) String() string {
+	return "file " + j.filename
+}
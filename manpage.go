@@ -0,0 +1,121 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManPage returns a roff-formatted man page describing the configuration of
+// the provided conf-tagged struct, using the same options as Parse. The page
+// is generated from the same information as the usage message, so the two
+// never disagree.
+func ManPage(v interface{}, options ...Option) (string, error) {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{template: sliceIndexTemplate, types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return "", err
+	}
+	fields = usageFields(fields, c)
+	prog := filepath.Base(os.Args[0])
+
+	var s strings.Builder
+	fmt.Fprintf(&s, ".TH %s 1\n", roffEscape(strings.ToUpper(prog)))
+
+	s.WriteString(".SH NAME\n")
+	s.WriteString(roffEscape(prog))
+	if c.description != "" {
+		fmt.Fprintf(&s, ` \- %s`, roffEscape(c.description))
+	}
+	s.WriteString("\n")
+
+	s.WriteString(".SH SYNOPSIS\n")
+	fmt.Fprintf(&s, ".B %s\n", roffEscape(prog))
+	s.WriteString("[\\fIoptions\\fR] [\\fIarguments\\fR]\n")
+
+	s.WriteString(".SH OPTIONS\n")
+	var env []field
+	for _, f := range fields {
+		typeName, help := getTypeAndHelp(&f)
+		name := c.usageName(f)
+		s.WriteString(".TP\n")
+		switch {
+		case name.flags:
+			fmt.Fprintf(&s, `\fB\-\-%s\fR`, roffEscape(f.flagName))
+			if f.options.short != 0 {
+				fmt.Fprintf(&s, `, \fB\-%s\fR`, roffEscape(string(f.options.short)))
+			}
+			// environment variables are described separately, referring
+			// back to the flags
+			if name.env != "" {
+				env = append(env, f)
+			}
+		case name.env != "":
+			fmt.Fprintf(&s, `\fB$%s\fR`, roffEscape(name.env))
+		default:
+			fmt.Fprintf(&s, `\fB%s\fR`, roffEscape(name.key))
+		}
+		if typeName != "" {
+			fmt.Fprintf(&s, ` \fI%s\fR`, roffEscape(typeName))
+		}
+		s.WriteString("\n")
+		writeRoffParagraph(&s, help, getOptString(f))
+	}
+
+	if len(env) > 0 {
+		s.WriteString(".SH ENVIRONMENT\n")
+		for _, f := range env {
+			_, help := getTypeAndHelp(&f)
+			s.WriteString(".TP\n")
+			fmt.Fprintf(&s, ".B %s\n", roffEscape(f.envName))
+			writeRoffParagraph(&s, help, fmt.Sprintf(`See --%s.`, f.flagName))
+		}
+	}
+
+	if len(c.confFiles) > 0 {
+		s.WriteString(".SH FILES\n")
+		for i, desc := range configFileDescriptions(c) {
+			s.WriteString(".TP\n")
+			fmt.Fprintf(&s, ".I %s\n", roffEscape(c.confFiles[i]))
+			writeRoffParagraph(&s, desc)
+		}
+	}
+	return s.String(), nil
+}
+
+// writeRoffParagraph writes each non-empty line of text, separated by line
+// breaks
+func writeRoffParagraph(s *strings.Builder, lines ...string) {
+	first := true
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if !first {
+			s.WriteString(".br\n")
+		}
+		s.WriteString(roffLine(line))
+		s.WriteString("\n")
+		first = false
+	}
+}
+
+// roffLine escapes a line of text, ensuring that it will not be interpreted
+// as a roff request
+func roffLine(s string) string {
+	s = roffEscape(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// roffEscape escapes characters that have special meaning within roff text
+func roffEscape(s string) string {
+	return strings.NewReplacer(`\`, `\e`, `-`, `\-`).Replace(s)
+}
@@ -0,0 +1,278 @@
+package conf
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces the values of `noprint` fields in redacted output
+const redactedValue = "REDACTED"
+
+// Marshal serializes the current values of the provided conf-tagged struct in
+// the specified format, such that they can be read back by the matching
+// config file source. Fields tagged with `noprint` are omitted, as are unset
+// pointer fields. Fields tagged with `secret` are masked, and so will not
+// survive the round trip.
+//
+// The conf format has no quoting, so values it cannot represent, such as those
+// with leading or trailing whitespace, or containing " #", which would be read
+// back as a comment, are an error. So are empty values of fields with a
+// non-empty default, which would be read back as the default.
+func Marshal(v interface{}, format Format, options ...Option) ([]byte, error) {
+	return marshal(v, format, false, options)
+}
+
+// MarshalRedacted is like Marshal, except fields tagged with `noprint` are
+// included with their values replaced with "REDACTED".
+func MarshalRedacted(v interface{}, format Format, options ...Option) ([]byte, error) {
+	return marshal(v, format, true, options)
+}
+
+func marshal(v interface{}, format Format, redact bool, options []Option) ([]byte, error) {
+	switch format {
+	case FormatConf, FormatDotenv, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported marshal format: %d", format)
+	}
+
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var s strings.Builder
+	root := map[string]interface{}{}
+	for _, f := range fields {
+		if (f.options.noprint && !redact) || f.options.secret.hidden() {
+			continue
+		}
+		value, ok := stringValue(f.field, f.options)
+		if !ok {
+			continue
+		}
+		var jsonVal interface{}
+		switch {
+		case f.options.noprint:
+			value, jsonVal = redactedValue, redactedValue
+		case f.options.secret.isSecret():
+			value = f.options.secret.apply(value)
+			jsonVal = value
+		default:
+			jsonVal = jsonValue(f.field, f.options)
+		}
+
+		switch format {
+		case FormatConf:
+			if !confRepresentable(value) {
+				return nil, fmt.Errorf("conf: value of field %s cannot be represented in the conf format", f.name)
+			}
+			// an empty value would be read back as a boolean flag, so is left
+			// to be read back as the zero value
+			if value == "" {
+				if f.options.defaultStr != "" {
+					return nil, fmt.Errorf("conf: empty value of field %s would be read back as its default in the conf format", f.name)
+				}
+				continue
+			}
+			fmt.Fprintf(&s, "%s %s\n", f.envName, value)
+		case FormatDotenv:
+			fmt.Fprintf(&s, "%s=%s\n", f.envName, dotenvQuote(value))
+		case FormatJSON:
+			setJSONPath(root, f.path, jsonVal)
+		}
+	}
+
+	if format == FormatJSON {
+		b, err := json.MarshalIndent(root, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	return []byte(s.String()), nil
+}
+
+// setJSONPath stores a value in a tree of JSON objects, creating intermediate
+// objects as needed. Numeric path segments, and the slice index template, are
+// indexes into arrays.
+func setJSONPath(root map[string]interface{}, path []string, value interface{}) {
+	name := path[0]
+	root[name] = setJSONNode(root[name], path[1:], value)
+}
+
+// setJSONNode stores a value at the path below node, returning the node
+func setJSONNode(node interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	if i, ok := jsonIndex(path[0]); ok {
+		array, _ := node.([]interface{})
+		for len(array) <= i {
+			array = append(array, nil)
+		}
+		array[i] = setJSONNode(array[i], path[1:], value)
+		return array
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		obj = map[string]interface{}{}
+	}
+	obj[path[0]] = setJSONNode(obj[path[0]], path[1:], value)
+	return obj
+}
+
+// jsonIndex returns the array index represented by a path segment, if any
+func jsonIndex(name string) (int, bool) {
+	if name == sliceIndexTemplate {
+		return 0, true
+	}
+	i, err := strconv.Atoi(name)
+	return i, err == nil && i >= 0
+}
+
+// confRepresentable reports whether a value would be read back verbatim from
+// a file in the conf format
+func confRepresentable(value string) bool {
+	return value == strings.TrimSpace(value) && !strings.Contains(value, " #") &&
+		!strings.ContainsAny(value, "\n\r")
+}
+
+// dotenvQuote quotes a value for a .env file if it would not otherwise be
+// read back verbatim
+func dotenvQuote(value string) string {
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#\"'\\\n\r\t") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// stringValue converts a field value into the string form understood by
+// processField. It returns false if the value is an unset pointer.
+func stringValue(v reflect.Value, opts fieldOptions) (string, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+
+	if bt, ok := opts.types.lookup(v.Type()); ok && bt.encode != nil {
+		if bt.pointer {
+			if !v.CanAddr() {
+				return "", false
+			}
+			return bt.encode(v.Addr().Interface()), true
+		}
+		return bt.encode(v.Interface()), true
+	}
+	if v.Type() == timeType {
+		return formatTime(v.Interface().(time.Time), opts.layout), true
+	}
+
+	if opts.types.decodesItself(v.Type()) {
+		if t := textMarshaler(v); t != nil {
+			b, err := t.MarshalText()
+			if err == nil {
+				return string(b), true
+			}
+		}
+		if s := stringer(v); s != nil {
+			return s.String(), true
+		}
+		return fmt.Sprintf("%v", v.Interface()), true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			return fmt.Sprint(v.Interface()), true
+		}
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, _ := stringValue(v.Index(i), opts.nested())
+			items = append(items, item)
+		}
+		return joinList(items, opts.separator()), true
+	case reflect.Map:
+		pairs := make([][2]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k, _ := stringValue(iter.Key(), opts.nested())
+			item, _ := stringValue(iter.Value(), opts.nested())
+			pairs = append(pairs, [2]string{k, item})
+		}
+		return joinMap(pairs, opts.separator(), opts.kvSeparator()), true
+	}
+	return fmt.Sprintf("%v", v.Interface()), true
+}
+
+// jsonValue converts a field value into a value suitable for encoding as JSON,
+// preserving numbers, booleans, arrays and objects where possible
+func jsonValue(v reflect.Value, opts fieldOptions) interface{} {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if opts.types.decodesItself(v.Type()) || v.Type() == durationType {
+		s, _ := stringValue(v, opts)
+		return s
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = jsonValue(v.Index(i), opts)
+		}
+		return items
+	case reflect.Map:
+		obj := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k, _ := stringValue(iter.Key(), opts)
+			obj[k] = jsonValue(iter.Value(), opts)
+		}
+		return obj
+	}
+	s, _ := stringValue(v, opts)
+	return s
+}
+
+func textMarshaler(field reflect.Value) (t encoding.TextMarshaler) {
+	interfaceFrom(field, func(v interface{}, ok *bool) { t, *ok = v.(encoding.TextMarshaler) })
+	return t
+}
+
+func stringer(field reflect.Value) (s fmt.Stringer) {
+	interfaceFrom(field, func(v interface{}, ok *bool) { s, *ok = v.(fmt.Stringer) })
+	return s
+}
@@ -1,30 +1,287 @@
 package conf
 
+import (
+	gocontext "context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
 // Option represents a change to the default parsing
 type Option func(c *context)
 
 // WithConfigFile tells parse to attempt to read from the specified file, if it
-// is found.
+// is found, in the simple `KEY value` format. It may be given more than once,
+// along with WithConfigSearchPath and WithXDGConfigFile, to layer several
+// files, each overriding the values in the files before it.
 func WithConfigFile(filename string) Option {
 	return func(c *context) {
-		c.confFile = filename
+		c.confFiles = append(c.confFiles, filename)
+	}
+}
+
+// WithConfigFileFormat is like WithConfigFile, but reads the file in the given
+// format, such as FormatDotenv or FormatJSON. Files given with the config file
+// flag are always read in the `KEY value` format.
+func WithConfigFileFormat(filename string, format Format) Option {
+	return func(c *context) {
+		if format != FormatConf {
+			if c.formats == nil {
+				c.formats = make(map[string]Format)
+			}
+			c.formats[filename] = format
+		}
+		c.confFiles = append(c.confFiles, filename)
+	}
+}
+
+// WithConfigSearchPath tells parse to attempt to read a file with the
+// specified name from each of the directories, in order, each overriding the
+// values in the files before it.
+func WithConfigSearchPath(filename string, dirs ...string) Option {
+	return func(c *context) {
+		for _, dir := range dirs {
+			c.confFiles = append(c.confFiles, filepath.Join(dir, filename))
+		}
+	}
+}
+
+// WithXDGConfigFile tells parse to attempt to read a file with the specified
+// name from the `app` subdirectory of each of the XDG base directories for
+// configuration: those in $XDG_CONFIG_DIRS (by default /etc/xdg), then
+// $XDG_CONFIG_HOME (by default ~/.config), which overrides them.
+func WithXDGConfigFile(app string, filename string) Option {
+	return func(c *context) {
+		for _, dir := range xdgConfigDirs() {
+			c.confFiles = append(c.confFiles, filepath.Join(dir, app, filename))
+		}
+	}
+}
+
+// WithConfigDropInDir tells parse to read every file ending in `.conf` in the
+// specified directory, in lexical order, each overriding the values in the
+// files before it. This allows packages to add configuration fragments to a
+// directory such as /etc/app/conf.d without editing a shared file. Like
+// WithConfigFile, it may be combined with other config files, and a missing
+// directory is skipped. Like the other files, drop-in directories are not read
+// if the config file flag is given.
+func WithConfigDropInDir(dir string) Option {
+	return func(c *context) {
+		pattern := filepath.Join(dir, "*.conf")
+		if c.dropIns == nil {
+			c.dropIns = make(map[string]bool)
+		}
+		c.dropIns[pattern] = true
+		c.confFiles = append(c.confFiles, pattern)
 	}
 }
 
 // WithConfigFileFlag tells parse to look for a flag called `flagname` and, if
-// it is found, to attempt to load configuration from this file. If the flag
-// is specified, it will override the value provided to WithConfigFile, if that
-// has been specified. If the file is not found, the program will exit with an
-// error.
+// it is found, to attempt to load configuration from this file. The flag may
+// be repeated to layer several files. If the flag is specified, it will
+// override the files provided to WithConfigFile, if any have been specified.
+// If a file is not found, the program will exit with an error.
 func WithConfigFileFlag(flagname string) Option {
 	return func(c *context) {
 		c.confFlag = flagname
 	}
 }
 
+// WithConfigTemplateFlag tells parse to look for a boolean flag called
+// `flagname` and, if it is found, to print a sample configuration file to
+// stdout and exit, in the same way the help flag prints usage.
+func WithConfigTemplateFlag(flagname string) Option {
+	return func(c *context) {
+		c.templateFlag = flagname
+	}
+}
+
 // WithSource adds additional configuration sources for configuration parsing
 func WithSource(source Source) Option {
 	return func(c *context) {
 		c.sources = append(c.sources, source)
 	}
 }
+
+// WithDescription provides a short, one-line description of the program for
+// use in generated documentation, such as the NAME section of a man page.
+func WithDescription(description string) Option {
+	return func(c *context) {
+		c.description = description
+	}
+}
+
+// WithDecoder teaches parse to convert strings into values of type t using
+// decode, which must return a value of type t or a pointer to one. It is
+// consulted before any other conversion, so it can also be used to override
+// the handling of types the library already supports.
+func WithDecoder(t reflect.Type, decode func(string) (interface{}, error)) Option {
+	return func(c *context) {
+		bt := c.customType(t)
+		bt.decode = decode
+		c.types[t] = bt
+	}
+}
+
+// WithEncoder provides the conversion of values of type t back into strings,
+// for use by String, Marshal, Diff, LogValue, Handler and the other exporters
+// given the option. It should produce strings accepted by the decoder for t.
+func WithEncoder(t reflect.Type, encode func(interface{}) string) Option {
+	return func(c *context) {
+		bt := c.customType(t)
+		bt.encode = encode
+		c.types[t] = bt
+	}
+}
+
+// WithTypeName sets the name shown for values of type t in the usage message
+// and in the man page, references and JSON schema generated with the option.
+// Types with a decoder but no name are shown by their lowercased Go type name.
+func WithTypeName(t reflect.Type, name string) Option {
+	return func(c *context) {
+		bt := c.customType(t)
+		bt.name = name
+		c.types[t] = bt
+	}
+}
+
+// WithEnum restricts fields of a string-backed type to the given values, such
+// as:
+//
+//	type Mode string
+//
+//	const (
+//		ModeFast Mode = "fast"
+//		ModeSlow Mode = "slow"
+//	)
+//
+//	conf.Parse(&c, conf.WithEnum(ModeFast, ModeSlow))
+//
+// Fields of the type behave as though they were tagged with `oneof`, listing
+// the values, in Parse and in any exporters given the option.
+func WithEnum[T ~string](values ...T) Option {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = string(v)
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	return func(c *context) {
+		bt := c.customType(t)
+		bt.values = strs
+		c.types[t] = bt
+	}
+}
+
+// WithContext provides a context for parsing, which is passed to sources
+// implementing ErrorSource. If it is cancelled, parsing is aborted.
+func WithContext(ctx gocontext.Context) Option {
+	return func(c *context) {
+		c.ctx = ctx
+	}
+}
+
+// WithInterpolation enables references of the form ${NAME} in values from
+// config files and defaults, which are replaced by the value of the config key
+// with the environment-style name NAME or, failing that, of the environment
+// variable NAME. ${NAME:-fallback} uses fallback if NAME is unset or empty,
+// and $${ is a literal ${. References are resolved after every source has been
+// consulted, so a default may refer to a value from a config file. Undefined
+// references and cycles are errors. Values from flags, the environment and
+// sources added with WithSource are used as they are.
+func WithInterpolation() Option {
+	return func(c *context) {
+		c.interpolate = true
+	}
+}
+
+// WithExec enables values which are produced by running a command, such as
+// the config file line:
+//
+//	DB_PASSWORD !exec pass show db
+//
+// The command line is split into arguments at whitespace, allowing for single
+// and double quotes, and run directly rather than by a shell. The value is the
+// command's standard output, less any trailing newline. With
+// WithInterpolation, references are expanded within each argument after
+// splitting, so their values never add arguments. Commands which fail or run
+// for longer than timeout (or 10 seconds, if timeout is 0) abort parsing;
+// their standard error is included in the error, except for fields tagged
+// noprint or secret.
+// If any commands are listed in allowed, only those may be run, and they must
+// be named exactly as listed.
+//
+// Commands are only run for values from config files and defaults, never for
+// values from flags, the environment or sources added with WithSource, which
+// may come from remote systems. Without WithExec, such values are used as they
+// are.
+func WithExec(timeout time.Duration, allowed ...string) Option {
+	return func(c *context) {
+		c.runner = &commandRunner{timeout: timeout}
+		if len(allowed) > 0 {
+			c.runner.allowed = make(map[string]bool, len(allowed))
+			for _, cmd := range allowed {
+				c.runner.allowed[cmd] = true
+			}
+		}
+	}
+}
+
+// WithProvenance has Parse record the source of each field's value in p, for
+// Handler to report when given the same option.
+func WithProvenance(p *Provenance) Option {
+	return func(c *context) {
+		c.provenance = p
+	}
+}
+
+// WithSourceOrder sets the order in which sources are consulted, from highest
+// precedence to lowest. Sources left out of the order are not consulted at
+// all. The default order is SourceFlags, SourceFile, SourceEnv, SourceExtra.
+func WithSourceOrder(order ...SourceID) Option {
+	return func(c *context) {
+		c.order = order
+	}
+}
+
+// WithoutSources disables the specified sources, leaving the order of the
+// others unchanged. If flags are disabled, only the help flag and any flags
+// set up by other options are recognized.
+func WithoutSources(ids ...SourceID) Option {
+	return func(c *context) {
+		if c.disabled == nil {
+			c.disabled = make(map[SourceID]bool)
+		}
+		for _, id := range ids {
+			c.disabled[id] = true
+		}
+	}
+}
+
+// xdgConfigDirs returns the XDG base directories for configuration, from
+// lowest precedence to highest. Relative paths are ignored, as required by
+// the specification.
+func xdgConfigDirs() []string {
+	var dirs []string
+	configDirs := filepath.SplitList(os.Getenv("XDG_CONFIG_DIRS"))
+	if len(configDirs) == 0 {
+		configDirs = []string{"/etc/xdg"}
+	}
+	// the first directory is the most important
+	for i := len(configDirs) - 1; i >= 0; i-- {
+		if filepath.IsAbs(configDirs[i]) {
+			dirs = append(dirs, configDirs[i])
+		}
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if filepath.IsAbs(configHome) {
+		dirs = append(dirs, configHome)
+	}
+	return dirs
+}
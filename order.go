@@ -0,0 +1,92 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceID identifies one of the kinds of source consulted by Parse, for use
+// with WithSourceOrder and WithoutSources
+type SourceID int
+
+const (
+	// SourceFlags is the command line flags
+	SourceFlags SourceID = iota
+	// SourceFile is the config file given by WithConfigFile or
+	// WithConfigFileFlag
+	SourceFile
+	// SourceEnv is the environment
+	SourceEnv
+	// SourceExtra is the sources added with WithSource, in the order they were
+	// added
+	SourceExtra
+)
+
+// defaultSourceOrder is the order in which sources are consulted, unless
+// changed with WithSourceOrder
+var defaultSourceOrder = []SourceID{SourceFlags, SourceFile, SourceEnv, SourceExtra}
+
+func (id SourceID) String() string {
+	switch id {
+	case SourceFlags:
+		return "flags"
+	case SourceFile:
+		return "config file"
+	case SourceEnv:
+		return "environment"
+	case SourceExtra:
+		return "additional sources"
+	}
+	return fmt.Sprintf("SourceID(%d)", int(id))
+}
+
+// sourceOrder returns the sources to consult, in order of precedence, with
+// any disabled sources removed
+func (c context) sourceOrder() ([]SourceID, error) {
+	order := defaultSourceOrder
+	if c.order != nil {
+		order = c.order
+	}
+	seen := make(map[SourceID]bool, len(order))
+	enabled := make([]SourceID, 0, len(order))
+	for _, id := range order {
+		if id < SourceFlags || id > SourceExtra {
+			return nil, fmt.Errorf("conf: unknown source %s", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("conf: source %s listed more than once", id)
+		}
+		seen[id] = true
+		if !c.disabled[id] {
+			enabled = append(enabled, id)
+		}
+	}
+	return enabled, nil
+}
+
+// sourceEnabled reports whether a source will be consulted
+func (c context) sourceEnabled(id SourceID) bool {
+	order, _ := c.sourceOrder()
+	for _, o := range order {
+		if o == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceOrderString describes the order in which sources are consulted, for
+// the usage message, omitting any which won't provide values
+func (c context) sourceOrderString() string {
+	order, _ := c.sourceOrder()
+	names := make([]string, 0, len(order)+1)
+	for _, id := range order {
+		switch {
+		case id == SourceFile && len(c.confFiles) == 0 && c.confFlag == "",
+			id == SourceExtra && len(c.sources) == 0:
+			continue
+		}
+		names = append(names, id.String())
+	}
+	return strings.Join(append(names, "defaults"), ", ")
+}
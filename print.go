@@ -2,26 +2,50 @@ package conf
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 )
 
 // String returns a stringified version of the provided conf-tagged
-// struct, minus any fields tagged with `noprint`
-func String(v interface{}) (string, error) {
-	fields, err := extractFields(nil, v)
+// struct, minus any fields tagged with `noprint`. Fields tagged with `secret`
+// are masked.
+func String(v interface{}, options ...Option) (string, error) {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{types: c.types}.extract(nil, nil, v)
 	if err != nil {
 		return "", err
 	}
-	var s strings.Builder
-	for i, field := range fields {
-		if !field.options.noprint {
-			s.WriteString(field.envName)
-			s.WriteString("=")
-			s.WriteString(fmt.Sprintf("%v", field.field.Interface()))
-			if i < len(fields)-1 {
-				s.WriteString(" ")
-			}
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field.options.noprint || field.options.secret.hidden() {
+			continue
 		}
+		value := fmt.Sprintf("%v", field.field.Interface())
+		if hasCustomType(field.field.Type(), c.types) || field.options.secret.isSecret() {
+			value, _ = stringValue(field.field, field.options)
+			value = field.options.secret.apply(value)
+		}
+		parts = append(parts, field.envName+"="+value)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// hasCustomType reports whether a type, or the type of the items of a
+// collection, has a conversion registered with options
+func hasCustomType(t reflect.Type, types typeConversions) bool {
+	t = derefType(t)
+	if _, ok := types[t]; ok {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return hasCustomType(t.Elem(), types)
+	case reflect.Map:
+		return hasCustomType(t.Key(), types) || hasCustomType(t.Elem(), types)
 	}
-	return s.String(), nil
+	return false
 }
@@ -9,9 +9,39 @@ import (
 	"time"
 )
 
-func processField(value string, field reflect.Value) error {
+func processField(value string, field reflect.Value, opts fieldOptions) error {
 	typ := field.Type()
 
+	// registered and common types are converted directly
+	if bt, ok := opts.types.lookup(typ); ok && bt.decode != nil {
+		if bt.pointer && typ.Kind() != reflect.Ptr {
+			return fmt.Errorf("only *%s is supported", typ)
+		}
+		val, err := bt.decode(value)
+		if err != nil {
+			return err
+		}
+		v := reflect.ValueOf(val)
+		if !v.IsValid() || derefType(v.Type()) != derefType(typ) {
+			return fmt.Errorf("decoder for %s returned %T", derefType(typ), val)
+		}
+		setValue(field, v)
+		return nil
+	}
+	if derefType(typ) == timeType {
+		t, err := parseTime(value, opts.layout)
+		if err != nil {
+			return err
+		}
+		setValue(field, reflect.ValueOf(t))
+		return nil
+	}
+
+	// allocate pointers first, so that methods aren't called on nil values
+	if typ.Kind() == reflect.Ptr && field.IsNil() {
+		field.Set(reflect.New(typ.Elem()))
+	}
+
 	// look for Set method
 	setter := setterFrom(field)
 	if setter != nil {
@@ -73,31 +103,49 @@ func processField(value string, field reflect.Value) error {
 		}
 		field.SetFloat(val)
 	case reflect.Slice:
-		vals := strings.Split(value, ",")
+		vals, err := splitList(value, opts.separator())
+		if err != nil {
+			return err
+		}
 		sl := reflect.MakeSlice(typ, len(vals), len(vals))
 		for i, val := range vals {
-			err := processField(val, sl.Index(i))
+			err := processField(val, sl.Index(i), opts.nested())
 			if err != nil {
 				return err
 			}
 		}
 		field.Set(sl)
+	case reflect.Array:
+		vals, err := splitList(value, opts.separator())
+		if err != nil {
+			return err
+		}
+		if len(vals) != typ.Len() {
+			return fmt.Errorf("expected %d items, got %d", typ.Len(), len(vals))
+		}
+		arr := reflect.New(typ).Elem()
+		for i, val := range vals {
+			err := processField(val, arr.Index(i), opts.nested())
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(arr)
 	case reflect.Map:
 		mp := reflect.MakeMap(typ)
 		if len(strings.TrimSpace(value)) != 0 {
-			pairs := strings.Split(value, ",")
+			pairs, err := splitMap(value, opts.separator(), opts.kvSeparator())
+			if err != nil {
+				return err
+			}
 			for _, pair := range pairs {
-				kvpair := strings.Split(pair, ":")
-				if len(kvpair) != 2 {
-					return fmt.Errorf("invalid map item: %q", pair)
-				}
 				k := reflect.New(typ.Key()).Elem()
-				err := processField(kvpair[0], k)
+				err := processField(pair[0], k, opts.nested())
 				if err != nil {
 					return err
 				}
 				v := reflect.New(typ.Elem()).Elem()
-				err = processField(kvpair[1], v)
+				err = processField(pair[1], v, opts.nested())
 				if err != nil {
 					return err
 				}
@@ -105,10 +153,93 @@ func processField(value string, field reflect.Value) error {
 			}
 		}
 		field.Set(mp)
+	default:
+		return fmt.Errorf("unsupported type %s", typ)
 	}
 	return nil
 }
 
+// processValue assigns a structured value from a ValueSource to a field.
+// Arrays and objects are assigned item by item to slices, arrays and maps,
+// and any other value is converted from its string form by processField.
+func processValue(value interface{}, field reflect.Value, opts fieldOptions) error {
+	t := derefType(field.Type())
+	if opts.types.decodesItself(t) {
+		return processField(valueString(value, opts), field, opts)
+	}
+
+	switch val := value.(type) {
+	case []interface{}:
+		var items reflect.Value
+		switch t.Kind() {
+		case reflect.Slice:
+			items = reflect.MakeSlice(t, len(val), len(val))
+		case reflect.Array:
+			if len(val) != t.Len() {
+				return fmt.Errorf("expected %d items, got %d", t.Len(), len(val))
+			}
+			items = reflect.New(t).Elem()
+		default:
+			return processField(valueString(value, opts), field, opts)
+		}
+		for i, item := range val {
+			if err := processValue(item, items.Index(i), opts.nested()); err != nil {
+				return err
+			}
+		}
+		setValue(field, items)
+	case map[string]interface{}:
+		if t.Kind() != reflect.Map {
+			return processField(valueString(value, opts), field, opts)
+		}
+		mp := reflect.MakeMap(t)
+		for k, item := range val {
+			key := reflect.New(t.Key()).Elem()
+			if err := processField(k, key, opts.nested()); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := processValue(item, elem, opts.nested()); err != nil {
+				return err
+			}
+			mp.SetMapIndex(key, elem)
+		}
+		setValue(field, mp)
+	default:
+		return processField(valueString(value, opts), field, opts)
+	}
+	return nil
+}
+
+// valueString converts a structured value from a ValueSource into the string
+// form understood by processField, joining arrays and objects with the
+// field's separators and quoting their items as needed
+func valueString(value interface{}, opts fieldOptions) string {
+	switch val := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float32:
+		return strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = valueString(item, opts.nested())
+		}
+		return joinList(items, opts.separator())
+	case map[string]interface{}:
+		pairs := make([][2]string, 0, len(val))
+		for k, item := range val {
+			pairs = append(pairs, [2]string{k, valueString(item, opts.nested())})
+		}
+		return joinMap(pairs, opts.separator(), opts.kvSeparator())
+	}
+	return fmt.Sprint(value)
+}
+
 func interfaceFrom(field reflect.Value, fn func(interface{}, *bool)) {
 	// it may be impossible for a struct field to fail this check
 	if !field.CanInterface() {
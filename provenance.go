@@ -0,0 +1,54 @@
+package conf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sourceDefault is the provenance of values taken from the `default` tag
+const sourceDefault = "default"
+
+// Provenance records the source each field's value was read from by Parse.
+// Pass the same Provenance to Parse and Handler with WithProvenance. It is
+// cleared at the start of every parse, and only filled in if parsing succeeds.
+// It is safe for concurrent use.
+type Provenance struct {
+	mu sync.RWMutex
+	// the source of each field's value, by environment name
+	sources map[string]string
+}
+
+// Source returns the source of the value of the field with the given
+// environment-style name, or "" if it was not set.
+func (p *Provenance) Source(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.sources[key]
+}
+
+func (p *Provenance) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sources = nil
+}
+
+func (p *Provenance) record(fields []field) {
+	sources := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if f.source != "" {
+			sources[f.envName] = f.source
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sources = sources
+}
+
+// sourceName returns a name for a source, for reporting where a value came
+// from. Sources may describe themselves by implementing fmt.Stringer.
+func sourceName(s Source) string {
+	if st, ok := s.(fmt.Stringer); ok {
+		return st.String()
+	}
+	return fmt.Sprintf("%T", s)
+}
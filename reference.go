@@ -0,0 +1,142 @@
+package conf
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// referenceColumns are the column headings for the reference tables
+var referenceColumns = []string{"Flag", "Short", "Environment", "Config Key", "Type", "Default", "Status", "Description"}
+
+// referenceGroup is a set of fields sharing the same struct prefix
+type referenceGroup struct {
+	name string
+	rows [][]string
+}
+
+// referenceGroups extracts fields from the provided struct and groups them by
+// their struct prefix, in the order in which each group is first encountered.
+// Fields at the top level are grouped under "General".
+func referenceGroups(v interface{}, options []Option) ([]referenceGroup, error) {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{template: sliceIndexTemplate, types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []referenceGroup
+	index := make(map[string]int)
+	for _, f := range fields {
+		name := "General"
+		if len(f.prefix) > 0 {
+			name = getFlagName(f.prefix)
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, referenceGroup{name: name})
+		}
+
+		typeName, help := getTypeAndHelp(&f)
+		var short string
+		if f.options.short != 0 {
+			short = "-" + string(f.options.short)
+		}
+		groups[i].rows = append(groups[i].rows, []string{
+			"--" + f.flagName,
+			short,
+			f.envName,
+			f.envName, // config file keys share the environment name
+			typeName,
+			f.displayDefault(),
+			getStatusString(f),
+			help,
+		})
+	}
+	return groups, nil
+}
+
+// getStatusString returns a short description of any special handling a
+// field receives
+func getStatusString(f field) string {
+	status := make([]string, 0, 3)
+	if f.options.required {
+		status = append(status, "required")
+	}
+	if f.options.noprint {
+		status = append(status, "noprint")
+	}
+	if f.options.secret.isSecret() {
+		status = append(status, "secret")
+	}
+	return strings.Join(status, ", ")
+}
+
+// MarkdownReference returns a Markdown reference for the provided conf-tagged
+// struct, with a table for each group of nested fields.
+func MarkdownReference(v interface{}, options ...Option) (string, error) {
+	groups, err := referenceGroups(v, options)
+	if err != nil {
+		return "", err
+	}
+
+	var s strings.Builder
+	for i, g := range groups {
+		if i > 0 {
+			s.WriteString("\n")
+		}
+		fmt.Fprintf(&s, "## %s\n\n", g.name)
+		fmt.Fprintf(&s, "| %s |\n", strings.Join(referenceColumns, " | "))
+		s.WriteString(strings.Repeat("| --- ", len(referenceColumns)) + "|\n")
+		for _, row := range g.rows {
+			s.WriteString("|")
+			for col, cell := range row {
+				cell = strings.ReplaceAll(cell, "|", `\|`)
+				// everything but the description is literal
+				if cell != "" && col != len(row)-1 {
+					cell = "`" + cell + "`"
+				}
+				fmt.Fprintf(&s, " %s |", cell)
+			}
+			s.WriteString("\n")
+		}
+	}
+	return s.String(), nil
+}
+
+// HTMLReference returns an HTML reference for the provided conf-tagged struct,
+// with a table for each group of nested fields.
+func HTMLReference(v interface{}, options ...Option) (string, error) {
+	groups, err := referenceGroups(v, options)
+	if err != nil {
+		return "", err
+	}
+
+	var s strings.Builder
+	for _, g := range groups {
+		fmt.Fprintf(&s, "<h2>%s</h2>\n<table>\n<thead>\n<tr>", html.EscapeString(g.name))
+		for _, col := range referenceColumns {
+			fmt.Fprintf(&s, "<th>%s</th>", html.EscapeString(col))
+		}
+		s.WriteString("</tr>\n</thead>\n<tbody>\n")
+		for _, row := range g.rows {
+			s.WriteString("<tr>")
+			for col, cell := range row {
+				cell = html.EscapeString(cell)
+				if cell != "" && col != len(row)-1 {
+					cell = "<code>" + cell + "</code>"
+				}
+				fmt.Fprintf(&s, "<td>%s</td>", cell)
+			}
+			s.WriteString("</tr>\n")
+		}
+		s.WriteString("</tbody>\n</table>\n")
+	}
+	return s.String(), nil
+}
@@ -0,0 +1,89 @@
+package conf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maskStrategy determines how the value of a secret field is masked
+type maskStrategy int
+
+const (
+	maskNone   maskStrategy = iota // not a secret
+	maskHidden                     // omitted entirely
+	maskStars                      // replaced with ****
+	maskFirst                      // first n characters shown
+	maskLast                       // last n characters shown
+	maskSHA256                     // replaced with a SHA-256 fingerprint
+)
+
+// maskedStars is the replacement text for masked values
+const maskedStars = "****"
+
+// mask describes how a secret field is displayed
+type mask struct {
+	strategy maskStrategy
+	n        int
+}
+
+// parseMask parses the value of the `secret` tag, which may be one of
+// `hidden`, `stars`, `sha256`, `firstN` or `lastN`, where N is the number of
+// characters to reveal.
+func parseMask(s string) (mask, error) {
+	switch s {
+	case "hidden":
+		return mask{strategy: maskHidden}, nil
+	case "stars":
+		return mask{strategy: maskStars}, nil
+	case "sha256":
+		return mask{strategy: maskSHA256}, nil
+	}
+	for prefix, strategy := range map[string]maskStrategy{"first": maskFirst, "last": maskLast} {
+		if strings.HasPrefix(s, prefix) {
+			n, err := strconv.Atoi(s[len(prefix):])
+			if err != nil || n < 1 {
+				return mask{}, fmt.Errorf("invalid secret mask %q: expected a positive number of characters", s)
+			}
+			return mask{strategy: strategy, n: n}, nil
+		}
+	}
+	return mask{}, fmt.Errorf("unknown secret mask %q", s)
+}
+
+// isSecret reports whether the mask applies to a secret field
+func (m mask) isSecret() bool {
+	return m.strategy != maskNone
+}
+
+// hidden reports whether values should be omitted entirely
+func (m mask) hidden() bool {
+	return m.strategy == maskHidden
+}
+
+// apply masks a value. Empty values are left empty, so that it remains
+// possible to tell whether a secret was set at all. Values which would be
+// revealed in their entirety by showing the first or last n characters are
+// replaced completely.
+func (m mask) apply(value string) string {
+	if m.strategy == maskNone || value == "" {
+		return value
+	}
+	runes := []rune(value)
+	switch m.strategy {
+	case maskFirst:
+		if len(runes) > m.n {
+			return string(runes[:m.n]) + maskedStars
+		}
+	case maskLast:
+		if len(runes) > m.n {
+			return maskedStars + string(runes[len(runes)-m.n:])
+		}
+	case maskSHA256:
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return maskedStars
+}
@@ -0,0 +1,109 @@
+package conf
+
+import (
+	"log/slog"
+	"reflect"
+)
+
+// LogValue returns a slog.LogValuer for the provided conf-tagged struct, so
+// that it can be logged as structured data:
+//
+//	slog.Info("config", "cfg", conf.LogValue(&c))
+//
+// The value is a group mirroring the nesting of the struct, keyed by the
+// flag-style name of each field. Fields tagged with `noprint` are omitted, and
+// those tagged with `secret` are masked. The struct is inspected when the
+// value is logged, not when LogValue is called.
+func LogValue(v interface{}, options ...Option) slog.LogValuer {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+	return logValuer{v: v, types: c.types}
+}
+
+// LogAttr returns a slog.Attr for the provided conf-tagged struct with the
+// given key. Its value is the same group produced by LogValue.
+func LogAttr(key string, v interface{}, options ...Option) slog.Attr {
+	return slog.Attr{Key: key, Value: LogValue(v, options...).LogValue()}
+}
+
+type logValuer struct {
+	v     interface{}
+	types typeConversions
+}
+
+// LogValue implements slog.LogValuer
+func (l logValuer) LogValue() slog.Value {
+	fields, err := extractor{types: l.types}.extract(nil, nil, l.v)
+	if err != nil {
+		return slog.AnyValue(err)
+	}
+
+	root := &logGroup{}
+	for _, f := range fields {
+		if f.options.noprint || f.options.secret.hidden() {
+			continue
+		}
+		g := root
+		for _, name := range f.path[:len(f.path)-1] {
+			g = g.child(name)
+		}
+		g.attrs = append(g.attrs, slog.Attr{Key: f.path[len(f.path)-1], Value: logFieldValue(f, l.types)})
+	}
+	return root.value()
+}
+
+// logFieldValue returns the value of a single field, masking it if it is a
+// secret, and encoding it if its type has a registered conversion
+func logFieldValue(f field, types typeConversions) slog.Value {
+	if f.options.secret.isSecret() {
+		value, _ := stringValue(f.field, f.options)
+		return slog.StringValue(f.options.secret.apply(value))
+	}
+	if hasCustomType(f.field.Type(), types) {
+		value, _ := stringValue(f.field, f.options)
+		return slog.StringValue(value)
+	}
+	v := f.field
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return slog.AnyValue(nil)
+		}
+		v = v.Elem()
+	}
+	return slog.AnyValue(v.Interface())
+}
+
+// logGroup collects the attributes of a nested struct, preserving the order
+// in which fields are declared
+type logGroup struct {
+	attrs  []slog.Attr
+	groups map[string]*logGroup
+	// index of each group's placeholder attribute within attrs
+	index map[string]int
+}
+
+// child returns the group with the given name, adding it if necessary
+func (g *logGroup) child(name string) *logGroup {
+	if c, ok := g.groups[name]; ok {
+		return c
+	}
+	if g.groups == nil {
+		g.groups = make(map[string]*logGroup)
+		g.index = make(map[string]int)
+	}
+	c := &logGroup{}
+	g.groups[name] = c
+	g.index[name] = len(g.attrs)
+	g.attrs = append(g.attrs, slog.Attr{Key: name})
+	return c
+}
+
+// value converts the group and its children into a slog group value
+func (g *logGroup) value() slog.Value {
+	for name, c := range g.groups {
+		g.attrs[g.index[name]].Value = c.value()
+	}
+	return slog.GroupValue(g.attrs...)
+}
@@ -0,0 +1,166 @@
+package conf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the default separators between the items of slices and maps, between the
+// keys and values of maps, and between the items of collections nested inside
+// them, which can be changed with the `sep`, `kvsep` and `nestedsep` tags
+const (
+	defaultSep       = ","
+	defaultKVSep     = ":"
+	defaultNestedSep = ";"
+)
+
+// separator returns the separator between the items of slices and maps
+func (f fieldOptions) separator() string {
+	if f.sep != "" {
+		return f.sep
+	}
+	return defaultSep
+}
+
+// kvSeparator returns the separator between the keys and values of maps
+func (f fieldOptions) kvSeparator() string {
+	if f.kvsep != "" {
+		return f.kvsep
+	}
+	return defaultKVSep
+}
+
+// nestedSeparator returns the separator between the items of collections
+// nested inside slices and maps
+func (f fieldOptions) nestedSeparator() string {
+	if f.nestedsep != "" {
+		return f.nestedsep
+	}
+	return defaultNestedSep
+}
+
+// nested returns the options for the items of a collection, so that any
+// collections among them are split on the nested separator
+func (f fieldOptions) nested() fieldOptions {
+	f.sep = f.nestedSeparator()
+	return f
+}
+
+// splitList splits a list of items on sep. As in CSV, an item may be enclosed
+// in double quotes, in which case it may contain the separator, and a double
+// quote is written as two double quotes.
+func splitList(value string, sep string) ([]string, error) {
+	var items []string
+	for {
+		item, rest, err := readItem(value, sep)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if rest == "" {
+			return items, nil
+		}
+		value = rest[len(sep):]
+	}
+}
+
+// splitMap splits a list of key/value pairs on sep, then each pair on the
+// first kvsep. Keys and values may be quoted in the same way as the items of
+// lists, and only quoted keys may contain kvsep.
+func splitMap(value string, sep string, kvsep string) ([][2]string, error) {
+	var pairs [][2]string
+	for {
+		k, rest, err := readItem(value, kvsep, sep)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(rest, kvsep) {
+			return nil, fmt.Errorf("invalid map item: %q", value[:len(value)-len(rest)])
+		}
+		v, rest, err := readItem(rest[len(kvsep):], sep)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2]string{k, v})
+		if rest == "" {
+			return pairs, nil
+		}
+		value = rest[len(sep):]
+	}
+}
+
+// readItem reads a single, possibly quoted, item from the start of value,
+// ending at the first of the terminators outside of quotes. It returns the
+// item and the remainder of value, starting with the terminator.
+func readItem(value string, terms ...string) (item string, rest string, err error) {
+	if !strings.HasPrefix(value, `"`) {
+		end := len(value)
+		for _, t := range terms {
+			if i := strings.Index(value, t); i >= 0 && i < end {
+				end = i
+			}
+		}
+		return value[:end], value[end:], nil
+	}
+
+	var s strings.Builder
+	rest = value[1:]
+	for {
+		i := strings.IndexByte(rest, '"')
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated quoted item: %s", value)
+		}
+		s.WriteString(rest[:i])
+		rest = rest[i+1:]
+		// a doubled quote is a literal quote
+		if !strings.HasPrefix(rest, `"`) {
+			break
+		}
+		s.WriteByte('"')
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return s.String(), rest, nil
+	}
+	for _, t := range terms {
+		if strings.HasPrefix(rest, t) {
+			return s.String(), rest, nil
+		}
+	}
+	return "", "", fmt.Errorf("unexpected text after quoted item: %s", value)
+}
+
+// joinList joins items with sep, quoting any which would not otherwise be
+// split back into the same items
+func joinList(items []string, sep string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = quoteItem(item, sep)
+	}
+	return strings.Join(quoted, sep)
+}
+
+// joinMap joins key/value pairs with kvsep and sep, sorted by key, quoting
+// keys and values as needed
+func joinMap(pairs [][2]string, sep string, kvsep string) string {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	items := make([]string, len(pairs))
+	for i, p := range pairs {
+		items[i] = quoteItem(p[0], sep, kvsep) + kvsep + quoteItem(p[1], sep)
+	}
+	return strings.Join(items, sep)
+}
+
+// quoteItem quotes an item if it contains any of the separators or starts
+// with a double quote
+func quoteItem(item string, seps ...string) string {
+	needsQuotes := strings.HasPrefix(item, `"`)
+	for _, sep := range seps {
+		needsQuotes = needsQuotes || strings.Contains(item, sep)
+	}
+	if !needsQuotes {
+		return item
+	}
+	return `"` + strings.ReplaceAll(item, `"`, `""`) + `"`
+}
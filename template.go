@@ -0,0 +1,88 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConfigTemplate returns a sample configuration file for the provided
+// conf-tagged struct in the specified format. Every key is commented out,
+// preceded by comments describing its help text and type, and filled in with
+// its default value, if any. Defaults for fields tagged with `noprint` are
+// left blank, as are those for fields tagged with `secret`. Since JSON does
+// not support comments, JSON templates contain only the default values, with
+// null for keys that have none. Slices of structs are represented by their
+// first element.
+func ConfigTemplate(v interface{}, format Format, options ...Option) (string, error) {
+	var c context
+	for _, option := range options {
+		option(&c)
+	}
+
+	fields, err := extractor{template: "0", types: c.types}.extract(nil, nil, v)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case FormatConf, FormatDotenv:
+	case FormatJSON:
+		return jsonTemplate(fields)
+	default:
+		return "", fmt.Errorf("unsupported config template format: %d", format)
+	}
+
+	var s strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			s.WriteString("\n")
+		}
+		typeName, help := getTypeAndHelp(&f)
+		if typeName == "" && f.boolField {
+			typeName = "<bool>"
+		}
+		if help != "" {
+			fmt.Fprintf(&s, "# %s\n", help)
+		}
+		s.WriteString("# " + typeName)
+		if f.options.required {
+			s.WriteString(" (required)")
+		}
+		s.WriteString("\n")
+
+		value := f.options.defaultStr
+		switch {
+		case f.options.noprint, f.options.secret.isSecret():
+			value = ""
+		case value == "" && f.boolField:
+			value = "false"
+		}
+		if format == FormatDotenv {
+			fmt.Fprintf(&s, "#%s=%s\n", f.envName, dotenvQuote(value))
+			continue
+		}
+		s.WriteString("#" + f.envName)
+		if value != "" {
+			s.WriteString(" " + value)
+		}
+		s.WriteString("\n")
+	}
+	return s.String(), nil
+}
+
+func jsonTemplate(fields []field) (string, error) {
+	root := map[string]interface{}{}
+	for _, f := range fields {
+		var value interface{}
+		if f.options.defaultStr != "" && !f.options.noprint && !f.options.secret.isSecret() {
+			value = schemaValue(f.options.defaultStr, f.field.Type(), f.options)
+		}
+		setJSONPath(root, f.path, value)
+	}
+	b, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
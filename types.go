@@ -0,0 +1,321 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinType describes how to convert a type which is common in
+// configuration, but which cannot be converted by processField on its own
+type builtinType struct {
+	// the type name shown in the usage message
+	name string
+	// converts a string into a value of the type
+	decode func(string) (interface{}, error)
+	// converts a value of the type back into a string
+	encode func(interface{}) string
+	// whether the type is only supported through a pointer, in which case
+	// decode and encode deal in pointers, which are assigned rather than
+	// having their values copied
+	pointer bool
+	// the only values accepted for the type, registered with WithEnum
+	values []string
+}
+
+// converts reports whether a type registered with options has its conversion
+// changed, rather than only having its values restricted by WithEnum
+func (bt builtinType) converts() bool {
+	return bt.values == nil || bt.decode != nil || bt.encode != nil || bt.name != ""
+}
+
+// builtinTypes are the types handled directly by processField, regardless of
+// any interfaces they implement
+var builtinTypes = map[reflect.Type]builtinType{
+	reflect.TypeOf(url.URL{}): {
+		name: "url",
+		decode: func(s string) (interface{}, error) {
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, err
+			}
+			return *u, nil
+		},
+		encode: func(v interface{}) string {
+			u := v.(url.URL)
+			return u.String()
+		},
+	},
+	reflect.TypeOf(net.IP{}): {
+		name: "ip",
+		decode: func(s string) (interface{}, error) {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", s)
+			}
+			return ip, nil
+		},
+		encode: func(v interface{}) string {
+			return v.(net.IP).String()
+		},
+	},
+	reflect.TypeOf(net.IPNet{}): {
+		name: "cidr",
+		decode: func(s string) (interface{}, error) {
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			return *ipNet, nil
+		},
+		encode: func(v interface{}) string {
+			ipNet := v.(net.IPNet)
+			return ipNet.String()
+		},
+	},
+	reflect.TypeOf(net.HardwareAddr{}): {
+		name: "mac",
+		decode: func(s string) (interface{}, error) {
+			return net.ParseMAC(s)
+		},
+		encode: func(v interface{}) string {
+			return v.(net.HardwareAddr).String()
+		},
+	},
+	reflect.TypeOf(netip.Addr{}): {
+		name: "ip",
+		decode: func(s string) (interface{}, error) {
+			return netip.ParseAddr(s)
+		},
+		encode: func(v interface{}) string {
+			return v.(netip.Addr).String()
+		},
+	},
+	reflect.TypeOf(netip.AddrPort{}): {
+		name: "ip:port",
+		decode: func(s string) (interface{}, error) {
+			return netip.ParseAddrPort(s)
+		},
+		encode: func(v interface{}) string {
+			return v.(netip.AddrPort).String()
+		},
+	},
+	reflect.TypeOf(netip.Prefix{}): {
+		name: "cidr",
+		decode: func(s string) (interface{}, error) {
+			return netip.ParsePrefix(s)
+		},
+		encode: func(v interface{}) string {
+			return v.(netip.Prefix).String()
+		},
+	},
+	reflect.TypeOf(time.Location{}): {
+		name: "timezone",
+		decode: func(s string) (interface{}, error) {
+			return time.LoadLocation(s)
+		},
+		encode: func(v interface{}) string {
+			return v.(*time.Location).String()
+		},
+		// locations such as time.Local are initialized lazily, so must not be
+		// copied
+		pointer: true,
+	},
+	reflect.TypeOf(ByteSize(0)): {
+		name: "size",
+		decode: func(s string) (interface{}, error) {
+			var b ByteSize
+			err := b.Set(s)
+			return b, err
+		},
+		encode: func(v interface{}) string {
+			return v.(ByteSize).String()
+		},
+	},
+	reflect.TypeOf(Rate{}): {
+		name: "rate",
+		decode: func(s string) (interface{}, error) {
+			var r Rate
+			err := r.Set(s)
+			return r, err
+		},
+		encode: func(v interface{}) string {
+			return v.(Rate).String()
+		},
+	},
+	reflect.TypeOf(regexp.Regexp{}): {
+		name: "regexp",
+		decode: func(s string) (interface{}, error) {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, err
+			}
+			return *re, nil
+		},
+		encode: func(v interface{}) string {
+			re := v.(regexp.Regexp)
+			return re.String()
+		},
+	},
+}
+
+// typeConversions holds the conversions for types registered with options
+type typeConversions map[reflect.Type]builtinType
+
+// lookup returns the conversion for a type, if there is one, looking through
+// any pointers. Conversions registered with options take precedence over the
+// builtin ones.
+func (tc typeConversions) lookup(t reflect.Type) (builtinType, bool) {
+	t = derefType(t)
+	if bt, ok := tc[t]; ok && bt.converts() {
+		if bt.name == "" {
+			bt.name = strings.ToLower(t.Name())
+		}
+		return bt, true
+	}
+	bt, ok := builtinTypes[t]
+	return bt, ok
+}
+
+// decodesItself reports whether values of the given type are converted
+// directly, without regard to their kind, rather than being drilled into or
+// split. This is true of types with registered or builtin conversions, and
+// of those able to deserialize themselves from a string.
+func (tc typeConversions) decodesItself(t reflect.Type) bool {
+	if bt, ok := tc[t]; ok && bt.converts() {
+		return true
+	}
+	if _, ok := builtinTypes[t]; ok {
+		return true
+	}
+	pt := reflect.PtrTo(t)
+	for _, i := range []reflect.Type{setterType, textUnmarshalerType, binaryUnmarshalerType} {
+		if t.Implements(i) || pt.Implements(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// setValue assigns a converted value to a field, allocating or dereferencing
+// pointers on either side as needed for the types to match
+func setValue(field reflect.Value, val reflect.Value) {
+	for field.Type() != val.Type() {
+		switch {
+		case field.Kind() == reflect.Ptr:
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		case val.Kind() == reflect.Ptr:
+			val = val.Elem()
+		default:
+			// let Set report the mismatch
+			field.Set(val)
+			return
+		}
+	}
+	field.Set(val)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// holdsTime reports whether values of the type are times, or pointers to,
+// or collections of times
+func holdsTime(t reflect.Type) bool {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return t == timeType
+		}
+	}
+}
+
+// the special layouts for times given as a count since the Unix epoch
+const (
+	layoutUnix      = "unix"
+	layoutUnixMilli = "unixms"
+	layoutUnixMicro = "unixus"
+	layoutUnixNano  = "unixns"
+)
+
+// timeLayouts are the named layouts accepted by the `layout` tag, in addition
+// to the Unix layouts. Any other value is used as a Go time layout.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"StampMilli":  time.StampMilli,
+	"StampMicro":  time.StampMicro,
+	"StampNano":   time.StampNano,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// resolveLayout converts the value of a `layout` tag into a Go time layout or
+// one of the Unix layouts. Times without a layout use RFC 3339.
+func resolveLayout(layout string) string {
+	if layout == "" {
+		return time.RFC3339
+	}
+	if l, ok := timeLayouts[layout]; ok {
+		return l
+	}
+	return layout
+}
+
+// parseTime parses a time according to the value of a `layout` tag
+func parseTime(value string, layout string) (time.Time, error) {
+	layout = resolveLayout(layout)
+	switch layout {
+	case layoutUnix, layoutUnixMilli, layoutUnixMicro, layoutUnixNano:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch layout {
+		case layoutUnix:
+			return time.Unix(n, 0), nil
+		case layoutUnixMilli:
+			return time.UnixMilli(n), nil
+		case layoutUnixMicro:
+			return time.UnixMicro(n), nil
+		}
+		return time.Unix(0, n), nil
+	}
+	return time.Parse(layout, value)
+}
+
+// formatTime formats a time according to the value of a `layout` tag
+func formatTime(t time.Time, layout string) string {
+	layout = resolveLayout(layout)
+	switch layout {
+	case layoutUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case layoutUnixMilli:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case layoutUnixMicro:
+		return strconv.FormatInt(t.UnixMicro(), 10)
+	case layoutUnixNano:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,192 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a number of bytes which can be configured in human-friendly
+// units, such as `512KiB`, `1.5GB` or `10M`. Units ending in `iB` are powers
+// of 1024 and units ending in `B` are powers of 1000. Single-letter units
+// (K, M, G, T, P, E) are powers of 1024. Units are case-insensitive, and a
+// bare number is a count of bytes.
+type ByteSize uint64
+
+// byte size units, in descending order
+var byteUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"EiB", 1 << 60},
+	{"EB", 1e18},
+	{"PiB", 1 << 50},
+	{"PB", 1e15},
+	{"TiB", 1 << 40},
+	{"TB", 1e12},
+	{"GiB", 1 << 30},
+	{"GB", 1e9},
+	{"MiB", 1 << 20},
+	{"MB", 1e6},
+	{"KiB", 1 << 10},
+	{"KB", 1e3},
+}
+
+// Set implements Setter
+func (b *ByteSize) Set(value string) error {
+	s := strings.TrimSpace(value)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == 0 {
+		return fmt.Errorf("invalid byte size: %q", value)
+	}
+	num, unit := s, ""
+	if i > 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+
+	mult, ok := byteUnitSize(unit)
+	if !ok {
+		return fmt.Errorf("invalid byte size unit %q in %q", unit, value)
+	}
+
+	// parse integers exactly, falling back to floats for fractional sizes
+	if n, err := strconv.ParseUint(num, 10, 64); err == nil {
+		if n > math.MaxUint64/mult {
+			return fmt.Errorf("byte size out of range: %q", value)
+		}
+		*b = ByteSize(n * mult)
+		return nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || !validAmount(f) {
+		return fmt.Errorf("invalid byte size: %q", value)
+	}
+	f *= float64(mult)
+	if f >= math.MaxUint64 {
+		return fmt.Errorf("byte size out of range: %q", value)
+	}
+	*b = ByteSize(f)
+	return nil
+}
+
+// validAmount reports whether a parsed number is a finite, non-negative
+// amount, since ParseFloat also accepts NaN, infinities and negative numbers
+func validAmount(f float64) bool {
+	return f >= 0 && !math.IsInf(f, 1)
+}
+
+// byteUnitSize returns the number of bytes in a unit
+func byteUnitSize(unit string) (uint64, bool) {
+	switch u := strings.ToUpper(unit); u {
+	case "", "B":
+		return 1, true
+	case "K", "M", "G", "T", "P", "E":
+		unit = u + "iB"
+	}
+	for _, bu := range byteUnits {
+		if strings.EqualFold(unit, bu.suffix) {
+			return bu.size, true
+		}
+	}
+	return 0, false
+}
+
+// String returns the size in the largest unit which represents it exactly,
+// preferring units of 1024
+func (b ByteSize) String() string {
+	n := uint64(b)
+	if n == 0 {
+		return "0B"
+	}
+	for _, preferBinary := range []bool{true, false} {
+		for _, bu := range byteUnits {
+			if strings.HasSuffix(bu.suffix, "iB") == preferBinary && n%bu.size == 0 {
+				return strconv.FormatUint(n/bu.size, 10) + bu.suffix
+			}
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
+
+// Rate is an amount per period of time, such as `100/s` or `5MB/s`. The amount
+// is either a plain number or a ByteSize, and the period is either a unit of
+// time (`ms`, `s`, `m`, `h`) or a duration, such as `/5m`.
+type Rate struct {
+	Amount float64
+	Per    time.Duration
+	// whether the amount was specified as a byte size
+	bytes bool
+}
+
+// Set implements Setter
+func (r *Rate) Set(value string) error {
+	amount, period, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return fmt.Errorf("invalid rate %q: expected amount/period", value)
+	}
+
+	// a period without a number, like "s", means one of that unit
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil {
+		return fmt.Errorf("invalid rate %q: %s", value, err)
+	}
+	if per <= 0 {
+		return errors.New("rate period must be positive")
+	}
+
+	var rate Rate
+	rate.Per = per
+	if rate.Amount, err = strconv.ParseFloat(amount, 64); err != nil || !validAmount(rate.Amount) {
+		var size ByteSize
+		if err := size.Set(amount); err != nil {
+			return fmt.Errorf("invalid rate %q: %s", value, err)
+		}
+		rate.Amount, rate.bytes = float64(size), true
+	}
+	*r = rate
+	return nil
+}
+
+// String returns the rate in the same form accepted by Set. The zero Rate is
+// represented by an empty string.
+func (r Rate) String() string {
+	if r == (Rate{}) {
+		return ""
+	}
+	var amount string
+	if r.bytes {
+		amount = ByteSize(r.Amount).String()
+	} else {
+		amount = strconv.FormatFloat(r.Amount, 'g', -1, 64)
+	}
+	var per string
+	switch r.Per {
+	case time.Millisecond:
+		per = "ms"
+	case time.Second:
+		per = "s"
+	case time.Minute:
+		per = "m"
+	case time.Hour:
+		per = "h"
+	default:
+		per = r.Per.String()
+	}
+	return amount + "/" + per
+}
+
+// PerSecond returns the rate as an amount per second
+func (r Rate) PerSecond() float64 {
+	if r.Per == 0 {
+		return 0
+	}
+	return r.Amount / r.Per.Seconds()
+}
@@ -2,6 +2,7 @@ package conf
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"sort"
@@ -9,46 +10,31 @@ import (
 	"text/tabwriter"
 )
 
-func printUsage(fields []field, c context) {
+func printUsage(out io.Writer, fields []field, c context) {
+	fields = usageFields(fields, c)
 
-	// sort the fields, by their long name
-	sort.SliceStable(fields, func(i, j int) bool {
-		return fields[i].flagName < fields[j].flagName
-	})
-
-	// put conf and help last
-	if c.confFlag != "" {
-		confFlagField := field{
-			flagName: c.confFlag,
-		}
-		if c.confFile != "" {
-			confFlagField.options.defaultStr = c.confFile
-			confFlagField.options.help = "the 'filename' to load configuration from"
-		}
-		fields = append(fields, confFlagField)
-	}
-	fields = append(fields, field{
-		flagName:  "help",
-		boolField: true,
-		options: fieldOptions{
-			short: 'h',
-			help:  "display this help message",
-		}})
+	fmt.Fprintf(out, "Usage: %s [options] [arguments]\n\n", os.Args[0])
 
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] [arguments]\n\n", os.Args[0])
-
-	fmt.Fprintln(os.Stderr, "OPTIONS")
+	fmt.Fprintln(out, "OPTIONS")
 	w := new(tabwriter.Writer)
-	w.Init(os.Stderr, 0, 4, 2, ' ', tabwriter.TabIndent)
+	w.Init(out, 0, 4, 2, ' ', tabwriter.TabIndent)
 
 	for _, f := range fields {
 		typeName, help := getTypeAndHelp(&f)
-		fmt.Fprintf(w, "  --%s", f.flagName)
-		if f.options.short != 0 {
-			fmt.Fprintf(w, "/-%s", string(f.options.short))
-		}
-		if f.envName != "" {
-			fmt.Fprintf(w, "/$%s", f.envName)
+		name := c.usageName(f)
+		switch {
+		case name.flags:
+			fmt.Fprintf(w, "  --%s", f.flagName)
+			if f.options.short != 0 {
+				fmt.Fprintf(w, "/-%s", string(f.options.short))
+			}
+			if name.env != "" {
+				fmt.Fprintf(w, "/$%s", name.env)
+			}
+		case name.env != "":
+			fmt.Fprintf(w, "  $%s", name.env)
+		default:
+			fmt.Fprintf(w, "  %s", name.key)
 		}
 		fmt.Fprintf(w, " %s\t%s\t\n", typeName, getOptString(f))
 		if help != "" {
@@ -56,14 +42,112 @@ func printUsage(fields []field, c context) {
 		}
 	}
 	w.Flush()
-	fmt.Fprintf(os.Stderr, "\n")
-	if c.confFile != "" {
-		fmt.Fprintf(os.Stderr, "FILES\n  %s\n    %s", c.confFile, "The system-wide configuration file")
+	fmt.Fprintf(out, "\n")
+	if len(c.confFiles) > 0 {
+		fmt.Fprintln(out, "FILES")
+		for i, desc := range configFileDescriptions(c) {
+			fmt.Fprintf(out, "  %s\n    %s\n", c.confFiles[i], desc)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "SOURCES\n  %s\n    %s\n\n", c.sourceOrderString(), "Values are taken from the first of these to set them")
+}
+
+// usageName describes how the usage message and man page refer to a field
+type usageName struct {
+	// whether the field is named by its flags
+	flags bool
+	// the field's environment variable, if the environment is consulted
+	env string
+	// the field's config file key, naming it if neither flags nor the
+	// environment are consulted
+	key string
+}
+
+// usageName returns how a field is named in the usage message and man page.
+// Fields are named by their flags if flags are consulted, and otherwise by
+// their environment variable or config file key. The special flags, which
+// have neither, are always named by their flags.
+func (c context) usageName(f field) usageName {
+	name := usageName{flags: c.sourceEnabled(SourceFlags) || f.envName == ""}
+	switch {
+	case c.sourceEnabled(SourceEnv):
+		name.env = f.envName
+	case !name.flags:
+		name.key = f.envName
+	}
+	return name
+}
+
+// configFileDescriptions describes each of the config files for the FILES
+// section of the usage message and man page
+func configFileDescriptions(c context) []string {
+	descs := make([]string, len(c.confFiles))
+	for i := range c.confFiles {
+		switch {
+		case c.dropIns[c.confFiles[i]] && i == 0:
+			descs[i] = "Configuration fragments, read in lexical order"
+		case c.dropIns[c.confFiles[i]]:
+			descs[i] = "Configuration fragments, read in lexical order, which override the files above"
+		case len(c.confFiles) == 1:
+			descs[i] = "The system-wide configuration file"
+		case i == 0:
+			descs[i] = "The base configuration file"
+		default:
+			descs[i] = "Overrides the files above"
+		}
 		if c.confFlag != "" {
-			fmt.Fprintf(os.Stderr, ` (overridden by --%s)`, c.confFlag)
+			descs[i] += fmt.Sprintf(" (overridden by --%s)", c.confFlag)
 		}
-		fmt.Fprint(os.Stderr, "\n\n")
 	}
+	return descs
+}
+
+// usageFields returns the fields sorted by their long name, followed by the
+// special config file, config template and help flags, in the order they
+// should be presented to the user.
+func usageFields(fields []field, c context) []field {
+	sorted := make([]field, len(fields), len(fields)+3)
+	copy(sorted, fields)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].flagName < sorted[j].flagName
+	})
+
+	// put conf and help last
+	if c.confFlag != "" {
+		confFlagField := field{
+			flagName: c.confFlag,
+			options: fieldOptions{
+				help: "the 'filename' to load configuration from; repeat to layer files",
+			},
+		}
+		// several files are given by repeating the flag, not in a list, so
+		// they're left to the FILES section
+		switch len(c.confFiles) {
+		case 0:
+		case 1:
+			confFlagField.options.defaultStr = c.confFiles[0]
+		default:
+			confFlagField.options.help += " (default: the files under FILES)"
+		}
+		sorted = append(sorted, confFlagField)
+	}
+	if c.templateFlag != "" {
+		sorted = append(sorted, field{
+			flagName:  c.templateFlag,
+			boolField: true,
+			options: fieldOptions{
+				help: "print a sample configuration file and exit",
+			},
+		})
+	}
+	return append(sorted, field{
+		flagName:  "help",
+		boolField: true,
+		options: fieldOptions{
+			short: 'h',
+			help:  "display this help message",
+		}})
 }
 
 // getTypeAndHelp extracts the type and help message for a single field for
@@ -74,22 +158,11 @@ func printUsage(fields []field, c context) {
 // type, manually-specified or not, since their presence is equated with a
 // 'true' value and their absence with a 'false' value. If a type cannot be
 // determined, it will simply give the name "value". Slices will be annotated
-// as "<Type>,[Type...]", where "Type" is whatever type name was chosen.
+// as "<Type>,[Type...]" (or with the separator from its `sep` tag), where
+// "Type" is whatever type name was chosen.
 // (adapted from package flag)
 func getTypeAndHelp(f *field) (name string, usage string) {
-	// Look for a single-quoted name
-	usage = f.options.help
-	for i := 0; i < len(usage); i++ {
-		if usage[i] == '\'' {
-			for j := i + 1; j < len(usage); j++ {
-				if usage[j] == '\'' {
-					name = usage[i+1 : j]
-					usage = usage[:i] + name + usage[j+1:]
-				}
-			}
-			break // Only one single quote; use type name.
-		}
-	}
+	name, usage = parseHelpType(f.options.help)
 
 	var isSlice bool
 	if f.field.IsValid() {
@@ -98,12 +171,27 @@ func getTypeAndHelp(f *field) (name string, usage string) {
 		if t.Kind() == reflect.Ptr {
 			t = t.Elem()
 		}
-		// if it's a slice, we want the type of the slice elements
-		if t.Kind() == reflect.Slice {
+		// if it's a slice, we want the type of the slice elements (unless it's
+		// a common type that happens to be a slice, like net.IP)
+		if !f.options.types.decodesItself(t) && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
 			t = t.Elem()
 			isSlice = true
 		}
 
+		// common types have their own names, and times are named by their
+		// layout
+		if t == timeType && name == "" {
+			name = resolveLayout(f.options.layout)
+		}
+		if bt, ok := f.options.types.lookup(t); ok && name == "" {
+			name = bt.name
+		}
+
+		// If no explicit name was provided, list the choices, if any
+		if name == "" && len(f.options.oneof) > 0 {
+			name = strings.Join(f.options.oneof, "|")
+		}
+
 		// If no explicit name was provided, attempt to get the type
 		if name == "" {
 			switch t.Kind() {
@@ -132,7 +220,7 @@ func getTypeAndHelp(f *field) (name string, usage string) {
 	}
 	switch {
 	case isSlice:
-		name = fmt.Sprintf("<%s>,[%s...]", name, name)
+		name = fmt.Sprintf("<%s>%s[%s...]", name, f.options.separator(), name)
 	case name != "":
 		name = fmt.Sprintf("<%s>", name)
 	default:
@@ -140,16 +228,37 @@ func getTypeAndHelp(f *field) (name string, usage string) {
 	return
 }
 
+// parseHelpType looks for a single-quoted name in a help message, returning
+// the name and the help message with the quotes removed.
+func parseHelpType(help string) (name string, usage string) {
+	usage = help
+	for i := 0; i < len(usage); i++ {
+		if usage[i] == '\'' {
+			for j := i + 1; j < len(usage); j++ {
+				if usage[j] == '\'' {
+					name = usage[i+1 : j]
+					usage = usage[:i] + name + usage[j+1:]
+				}
+			}
+			break // Only one single quote; use type name.
+		}
+	}
+	return
+}
+
 func getOptString(f field) string {
-	opts := make([]string, 0, 3)
+	opts := make([]string, 0, 4)
 	if f.options.required {
 		opts = append(opts, "required")
 	}
 	if f.options.noprint {
 		opts = append(opts, "noprint")
 	}
-	if f.options.defaultStr != "" {
-		opts = append(opts, fmt.Sprintf("default: %s", f.options.defaultStr))
+	if f.options.secret.isSecret() {
+		opts = append(opts, "secret")
+	}
+	if f.options.defaultStr != "" && !f.options.secret.hidden() {
+		opts = append(opts, fmt.Sprintf("default: %s", f.options.secret.apply(f.options.defaultStr)))
 	}
 	if len(opts) > 0 {
 		return fmt.Sprintf("(%s)", strings.Join(opts, `,`))